@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/Ayannamdeo/chirpy/internal/middleware"
+	"github.com/google/uuid"
+)
+
+const defaultDeletionGrace = 30 * 24 * time.Hour
+
+// deleteUserHandler schedules the caller's account for deletion after the
+// grace period and logs it out everywhere. Logging back in before then
+// keeps the account.
+func (cfg *apiConfig) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	reqBody := struct {
+		Password string `json:"password"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	user, err := cfg.db.GetUserById(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.HashedPassword == "" {
+		respondWithError(w, http.StatusBadRequest, "set a password with a password reset first", nil)
+		return
+	}
+	emailKey := strings.ToLower(strings.TrimSpace(user.Email))
+	ip := cfg.clientIP(r)
+	if cfg.loginThrottled(w, r, emailKey, ip) {
+		return
+	}
+	if err := auth.CheckPasswordHash(reqBody.Password, user.HashedPassword); err != nil {
+		cfg.recordLoginFailure(r.Context(), emailKey, ip)
+		respondWithError(w, http.StatusUnauthorized, "incorrect password", err)
+		return
+	}
+
+	deleteAfter := time.Now().UTC().Add(cfg.deletionGrace)
+	err = cfg.db.ScheduleUserDeletion(r.Context(), database.ScheduleUserDeletionParams{
+		DeleteAfter: sql.NullTime{Time: deleteAfter, Valid: true},
+		ID:          userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't schedule deletion", err)
+		return
+	}
+	if err := cfg.revokeAllSessions(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	if err := cfg.db.RevokeAllApiTokensForUser(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API tokens", err)
+		return
+	}
+	cfg.audit(r, auditDeletionRequested, userID, userID, auditMeta{"delete_after": deleteAfter})
+	if cookieMode(r) {
+		cfg.clearSessionCookies(w)
+	}
+
+	type response struct {
+		DeleteAfter time.Time `json:"delete_after"`
+	}
+	respondWithJSON(w, http.StatusAccepted, response{
+		DeleteAfter: deleteAfter,
+	})
+}
+
+// cancelDeletion keeps an account that is pending deletion, because its
+// owner just logged in.
+func (cfg *apiConfig) cancelDeletion(r *http.Request, user database.User) error {
+	cancelled, err := cfg.db.CancelUserDeletion(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+	if cancelled > 0 {
+		cfg.audit(r, auditDeletionCancelled, user.ID, user.ID, nil)
+	}
+	return nil
+}
+
+// runAccountPurge deletes accounts whose grace period is over every
+// interval until ctx is done. Their chirps, sessions and tokens go with them
+// through the foreign keys.
+func (cfg *apiConfig) runAccountPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := cfg.db.PurgeDeletedUsers(ctx)
+			if err != nil {
+				log.Printf("Error purging deleted accounts: %s", err)
+				continue
+			}
+			for _, userID := range purged {
+				cfg.recordAudit(ctx, auditUserPurged, uuid.Nil, userID, "", "", nil)
+			}
+		}
+	}
+}
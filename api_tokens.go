@@ -0,0 +1,141 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/Ayannamdeo/chirpy/internal/middleware"
+	"github.com/google/uuid"
+)
+
+// APIToken is a personal access token as its owner sees it. Token is only
+// set in the response that creates it; afterwards only the hash is kept.
+type APIToken struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Token      string     `json:"token,omitempty"`
+}
+
+func apiTokenFromDB(token database.ApiToken) APIToken {
+	resp := APIToken{
+		ID:        token.ID,
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt,
+	}
+	if token.ExpiresAt.Valid {
+		resp.ExpiresAt = &token.ExpiresAt.Time
+	}
+	if token.LastUsedAt.Valid {
+		resp.LastUsedAt = &token.LastUsedAt.Time
+	}
+	return resp
+}
+
+// createAPITokenHandler needs a login access token: a personal access
+// token can't be used to mint more of them.
+func (cfg *apiConfig) createAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	reqBody := struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if reqBody.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "name is required", nil)
+		return
+	}
+	if len(reqBody.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "at least one scope is required", nil)
+		return
+	}
+	scopes := []string{}
+	for _, scope := range reqBody.Scopes {
+		if !auth.ValidScope(scope) {
+			respondWithError(w, http.StatusBadRequest, "unknown scope "+scope, nil)
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	expiresAt := sql.NullTime{}
+	if reqBody.ExpiresAt != nil {
+		if !reqBody.ExpiresAt.After(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "expires_at must be in the future", nil)
+			return
+		}
+		expiresAt = sql.NullTime{Time: reqBody.ExpiresAt.UTC(), Valid: true}
+	}
+
+	token, err := auth.MakeAPIToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't make API token", err)
+		return
+	}
+	created, err := cfg.db.CreateApiToken(r.Context(), database.CreateApiTokenParams{
+		UserID:    userID,
+		Name:      reqBody.Name,
+		TokenHash: auth.HashToken(token),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save API token", err)
+		return
+	}
+	cfg.audit(r, auditAPITokenCreated, userID, userID, auditMeta{"token_id": created.ID, "name": created.Name, "scopes": created.Scopes})
+	resp := apiTokenFromDB(created)
+	resp.Token = token
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (cfg *apiConfig) listAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	tokens, err := cfg.db.ListApiTokensForUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list API tokens", err)
+		return
+	}
+	resp := []APIToken{}
+	for _, token := range tokens {
+		resp = append(resp, apiTokenFromDB(token))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) revokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID format", err)
+		return
+	}
+	revoked, err := cfg.db.RevokeApiToken(r.Context(), database.RevokeApiTokenParams{
+		ID:     tokenID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API token", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "API token not found", nil)
+		return
+	}
+	cfg.audit(r, auditAPITokenRevoked, userID, userID, auditMeta{"token_id": tokenID})
+	w.WriteHeader(http.StatusNoContent)
+}
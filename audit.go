@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	auditPageSize    = 50
+	auditMaxPageSize = 200
+)
+
+// Audit event types, as stored in audit_events.event_type.
+const (
+	auditLogin                = "login"
+	auditLoginFailed          = "login.failed"
+	auditMagicLinkSent        = "login.magic_link_sent"
+	auditTwoFactorFailed      = "2fa.failed"
+	auditTwoFactorEnabled     = "2fa.enabled"
+	auditTwoFactorDisabled    = "2fa.disabled"
+	auditPasswordChanged      = "password.changed"
+	auditPasswordReset        = "password.reset"
+	auditEmailChanged         = "email.changed"
+	auditTokenRefreshed       = "token.refreshed"
+	auditTokenReuse           = "token.reuse_detected"
+	auditSessionRevoked       = "session.revoked"
+	auditAPITokenCreated      = "api_token.created"
+	auditAPITokenRevoked      = "api_token.revoked"
+	auditPasskeyAdded         = "passkey.added"
+	auditPasskeyRemoved       = "passkey.removed"
+	auditDeviceApproved       = "oauth.device_approved"
+	auditChirpyRedUpgrade     = "user.chirpy_red"
+	auditRoleChanged          = "user.role_changed"
+	auditDeletionRequested    = "user.deletion_requested"
+	auditDeletionCancelled    = "user.deletion_cancelled"
+	auditUserPurged           = "user.purged"
+	auditImpersonationStarted = "impersonation.started"
+	auditImpersonatedRequest  = "impersonation.request"
+)
+
+type auditMeta map[string]any
+
+// audit appends an event to the audit log. actorID is who did it and
+// userID the account it happened to, uuid.Nil when there is none. Losing
+// an event is logged but doesn't fail the request it describes.
+func (cfg *apiConfig) audit(r *http.Request, eventType string, actorID, userID uuid.UUID, metadata auditMeta) {
+	cfg.recordAudit(r.Context(), eventType, actorID, userID, cfg.clientIP(r), r.UserAgent(), metadata)
+}
+
+// recordAudit is audit for events that no request is behind, like those
+// of background jobs.
+func (cfg *apiConfig) recordAudit(ctx context.Context, eventType string, actorID, userID uuid.UUID, ipAddress, userAgent string, metadata auditMeta) {
+	if metadata == nil {
+		metadata = auditMeta{}
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		log.Printf("Error encoding audit event %s: %v", eventType, err)
+		return
+	}
+	err = cfg.db.CreateAuditEvent(ctx, database.CreateAuditEventParams{
+		EventType: eventType,
+		ActorID:   uuid.NullUUID{UUID: actorID, Valid: actorID != uuid.Nil},
+		UserID:    uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil},
+		IpAddress: ipAddress,
+		UserAgent: userAgent,
+		Metadata:  data,
+	})
+	if err != nil {
+		log.Printf("Error writing audit event %s for user %s: %v", eventType, userID, err)
+	}
+}
+
+type AuditEvent struct {
+	ID        uuid.UUID       `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	EventType string          `json:"event_type"`
+	ActorID   *uuid.UUID      `json:"actor_id"`
+	UserID    *uuid.UUID      `json:"user_id"`
+	IPAddress string          `json:"ip_address"`
+	UserAgent string          `json:"user_agent"`
+	Metadata  json.RawMessage `json:"metadata"`
+}
+
+func auditEventFromDB(event database.AuditEvent) AuditEvent {
+	e := AuditEvent{
+		ID:        event.ID,
+		CreatedAt: event.CreatedAt,
+		EventType: event.EventType,
+		IPAddress: event.IpAddress,
+		UserAgent: event.UserAgent,
+		Metadata:  event.Metadata,
+	}
+	if event.ActorID.Valid {
+		e.ActorID = &event.ActorID.UUID
+	}
+	if event.UserID.Valid {
+		e.UserID = &event.UserID.UUID
+	}
+	return e
+}
+
+// The cursor of the next page is the position of the last event returned,
+// opaque to clients.
+func encodeAuditCursor(event database.AuditEvent) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(event.CreatedAt.Format(time.RFC3339Nano) + "," + event.ID.String()))
+}
+
+func decodeAuditCursor(cursor string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	createdAt, id, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return time.Time{}, uuid.Nil, errors.New("malformed cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	eventID, err := uuid.Parse(id)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	return t, eventID, nil
+}
+
+func parseAuditTime(v string) (sql.NullTime, error) {
+	if v == "" {
+		return sql.NullTime{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}, nil
+}
+
+// listAuditEventsHandler pages through the audit log, newest first.
+// Filters: type, user_id (as actor or subject), since and until (RFC 3339).
+func (cfg *apiConfig) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	params := database.ListAuditEventsParams{Limit: auditPageSize}
+	if eventType := query.Get("type"); eventType != "" {
+		params.EventType.String, params.EventType.Valid = eventType, true
+	}
+	if userID := query.Get("user_id"); userID != "" {
+		id, err := uuid.Parse(userID)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid user_id", err)
+			return
+		}
+		params.UserID.UUID, params.UserID.Valid = id, true
+	}
+	var err error
+	if params.Since, err = parseAuditTime(query.Get("since")); err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid since, want RFC 3339", err)
+		return
+	}
+	if params.Until, err = parseAuditTime(query.Get("until")); err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid until, want RFC 3339", err)
+		return
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > auditMaxPageSize {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(auditMaxPageSize), err)
+			return
+		}
+		params.Limit = int32(limit)
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		createdAt, id, err := decodeAuditCursor(cursor)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid cursor", err)
+			return
+		}
+		params.BeforeCreatedAt.Time, params.BeforeCreatedAt.Valid = createdAt, true
+		params.BeforeID.UUID, params.BeforeID.Valid = id, true
+	}
+
+	events, err := cfg.db.ListAuditEvents(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list audit events", err)
+		return
+	}
+	type response struct {
+		Events     []AuditEvent `json:"events"`
+		NextCursor string       `json:"next_cursor,omitempty"`
+	}
+	res := response{Events: make([]AuditEvent, 0, len(events))}
+	for _, event := range events {
+		res.Events = append(res.Events, auditEventFromDB(event))
+	}
+	if len(events) == int(params.Limit) {
+		res.NextCursor = encodeAuditCursor(events[len(events)-1])
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
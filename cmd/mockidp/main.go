@@ -0,0 +1,35 @@
+// Command mockidp runs the oidctest provider locally so OIDC login can be
+// tried without a real identity provider. Point Chirpy at it with
+// OIDC_ISSUER=http://localhost:9000 OIDC_CLIENT_ID=chirpy OIDC_CLIENT_SECRET=secret.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/Ayannamdeo/chirpy/internal/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "address to listen on")
+	clientID := flag.String("client-id", "chirpy", "client ID Chirpy uses")
+	clientSecret := flag.String("client-secret", "secret", "client secret Chirpy uses, empty for a public client")
+	subject := flag.String("sub", "mockidp-user", "subject of the user everyone logs in as")
+	email := flag.String("email", "sso-user@example.com", "email of the user everyone logs in as")
+	emailVerified := flag.Bool("email-verified", true, "whether the email is verified")
+	flag.Parse()
+
+	idp, err := oidctest.New("http://"+*addr, *clientID, *clientSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	idp.SetUser(oidctest.User{
+		Subject:       *subject,
+		Email:         *email,
+		EmailVerified: *emailVerified,
+		Name:          "Mock IdP User",
+	})
+	log.Printf("mock OIDC provider for client %q at %s", *clientID, idp.Issuer)
+	log.Fatal(http.ListenAndServe(*addr, idp))
+}
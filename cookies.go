@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/middleware"
+)
+
+func cookieMode(r *http.Request) bool {
+	return r.Header.Get(middleware.SessionModeHeader) == middleware.SessionModeCookie
+}
+
+// secureCookies is off only for plain-HTTP development setups, where the
+// browser wouldn't send Secure cookies back.
+func (cfg *apiConfig) secureCookies() bool {
+	return strings.HasPrefix(cfg.publicURL, "https://")
+}
+
+// setSessionCookies hands a token pair to the browser, along with a fresh
+// CSRF token. The refresh token is only sent to /api, where it is used.
+func (cfg *apiConfig) setSessionCookies(w http.ResponseWriter, tokens sessionTokens) error {
+	csrfToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.AccessTokenCookie,
+		Value:    tokens.AccessToken,
+		Path:     "/",
+		MaxAge:   int(tokens.AccessTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.RefreshTokenCookie,
+		Value:    tokens.RefreshToken,
+		Path:     "/api",
+		MaxAge:   int(time.Until(tokens.Session.ExpiresAt).Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.CSRFCookie,
+		Value:    csrfToken,
+		Path:     "/",
+		MaxAge:   int(time.Until(tokens.Session.ExpiresAt).Seconds()),
+		Secure:   cfg.secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func (cfg *apiConfig) clearSessionCookies(w http.ResponseWriter) {
+	for name, path := range map[string]string{
+		middleware.AccessTokenCookie:  "/",
+		middleware.RefreshTokenCookie: "/api",
+		middleware.CSRFCookie:         "/",
+	} {
+		http.SetCookie(w, &http.Cookie{
+			Name:   name,
+			Path:   path,
+			MaxAge: -1,
+		})
+	}
+}
+
+// refreshTokenFromRequest reads the refresh token of /api/refresh and
+// /api/revoke, from the Authorization header or, in cookie mode, from its
+// cookie after checking the CSRF token.
+func refreshTokenFromRequest(r *http.Request) (token string, fromCookie bool, err error) {
+	if r.Header.Get("Authorization") == "" {
+		if cookie, err := r.Cookie(middleware.RefreshTokenCookie); err == nil {
+			if err := middleware.CheckCSRF(r); err != nil {
+				return "", true, err
+			}
+			return cookie.Value, true, nil
+		}
+	}
+	token, err = auth.GetBearerToken(r.Header)
+	return token, false, err
+}
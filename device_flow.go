@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/Ayannamdeo/chirpy/internal/middleware"
+	"github.com/google/uuid"
+)
+
+const (
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+	deviceCodeTTL       = 10 * time.Minute
+	// devicePollInterval is how long clients must wait between polls of
+	// the token endpoint.
+	devicePollInterval = 5 * time.Second
+
+	// userCodeAlphabet has no vowels, so codes don't spell words, and
+	// nothing that is easily mistaken for something else (RFC 8628
+	// section 6.1).
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// Statuses of oauth_device_codes.
+const (
+	deviceCodePending  = "pending"
+	deviceCodeApproved = "approved"
+	deviceCodeDenied   = "denied"
+)
+
+func makeUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode undoes what users do to codes when typing them: lower
+// case, the dash in the middle, spaces.
+func normalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
+
+func formatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+// deviceAuthorizationHandler starts the device flow (RFC 8628) for clients
+// that can't show a browser, such as CLIs. The client shows the user code
+// and polls the token endpoint while the user approves it on the device
+// page.
+func (cfg *apiConfig) deviceAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, &oauthError{Code: "invalid_request", Description: "couldn't parse form"})
+		return
+	}
+	client, oauthErr := cfg.authenticateOAuthClient(r)
+	if oauthErr != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, oauthErr)
+		return
+	}
+	scopes, oauthErr := clientScopes(client, r.PostFormValue("scope"))
+	if oauthErr != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErr)
+		return
+	}
+	if err := cfg.db.DeleteExpiredOauthDeviceCodes(r.Context()); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't clean up device codes", err)
+		return
+	}
+	deviceCode, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't make device code", err)
+		return
+	}
+	userCode, err := makeUserCode()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't make user code", err)
+		return
+	}
+	err = cfg.db.CreateOauthDeviceCode(r.Context(), database.CreateOauthDeviceCodeParams{
+		DeviceCodeHash: auth.HashToken(deviceCode),
+		UserCode:       userCode,
+		ClientID:       client.ID,
+		Scopes:         scopes,
+		ExpiresAt:      time.Now().UTC().Add(deviceCodeTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save device code", err)
+		return
+	}
+
+	type response struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int    `json:"expires_in"`
+		Interval                int    `json:"interval"`
+	}
+	verificationURI := cfg.publicURL + "/app/device.html"
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, response{
+		DeviceCode:              deviceCode,
+		UserCode:                formatUserCode(userCode),
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + url.QueryEscape(formatUserCode(userCode)),
+		ExpiresIn:               int(deviceCodeTTL.Seconds()),
+		Interval:                int(devicePollInterval.Seconds()),
+	})
+}
+
+// deviceCodeTokens is the device code grant of oauthTokenHandler. Until
+// the user has made up their mind it answers authorization_pending, or
+// slow_down to clients polling too often.
+func (cfg *apiConfig) deviceCodeTokens(r *http.Request, client database.OauthClient) (sessionTokens, *oauthError, error) {
+	invalidGrant := &oauthError{Code: "invalid_grant", Description: "the device code is invalid or was already used"}
+	deviceCodeHash := auth.HashToken(r.PostFormValue("device_code"))
+	code, err := cfg.db.GetOauthDeviceCode(r.Context(), deviceCodeHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sessionTokens{}, invalidGrant, nil
+	}
+	if err != nil {
+		return sessionTokens{}, nil, err
+	}
+	if code.ClientID != client.ID || code.UsedAt.Valid {
+		return sessionTokens{}, invalidGrant, nil
+	}
+	if time.Now().UTC().After(code.ExpiresAt) {
+		return sessionTokens{}, &oauthError{Code: "expired_token", Description: "the device code has expired"}, nil
+	}
+
+	switch code.Status {
+	case deviceCodeDenied:
+		return sessionTokens{}, &oauthError{Code: "access_denied", Description: "the user denied the request"}, nil
+	case deviceCodePending:
+		if err := cfg.db.TouchOauthDeviceCode(r.Context(), deviceCodeHash); err != nil {
+			return sessionTokens{}, nil, err
+		}
+		if code.LastPolledAt.Valid && time.Now().UTC().Sub(code.LastPolledAt.Time) < devicePollInterval {
+			return sessionTokens{}, &oauthError{Code: "slow_down", Description: "polling too often"}, nil
+		}
+		return sessionTokens{}, &oauthError{Code: "authorization_pending", Description: "the user hasn't approved the request yet"}, nil
+	}
+
+	code, err = cfg.db.UseOauthDeviceCode(r.Context(), deviceCodeHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sessionTokens{}, invalidGrant, nil
+	}
+	if err != nil {
+		return sessionTokens{}, nil, err
+	}
+	tokens, err := cfg.startSession(r, code.UserID.UUID, client.Name, sql.NullString{String: client.ID, Valid: true}, code.Scopes)
+	return tokens, nil, err
+}
+
+// pendingDeviceCode looks up the code the user typed on the device page.
+// Wrong codes count against the user, so codes can't be guessed by
+// trying them all.
+func (cfg *apiConfig) pendingDeviceCode(w http.ResponseWriter, r *http.Request, userCode string) (database.OauthDeviceCode, database.OauthClient, bool) {
+	userKey := middleware.FromContext(r.Context()).UserID.String()
+	wait, err := cfg.deviceCodeThrottle.Check(r.Context(), userKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check failed attempts", err)
+		return database.OauthDeviceCode{}, database.OauthClient{}, false
+	}
+	if wait > 0 {
+		respondWithRetryAfter(w, wait, "too many wrong codes, try again later")
+		return database.OauthDeviceCode{}, database.OauthClient{}, false
+	}
+	code, err := cfg.db.GetPendingOauthDeviceCode(r.Context(), normalizeUserCode(userCode))
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := cfg.deviceCodeThrottle.Fail(r.Context(), userKey); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record failed attempt", err)
+			return database.OauthDeviceCode{}, database.OauthClient{}, false
+		}
+		respondWithError(w, http.StatusNotFound, "unknown or expired code", nil)
+		return database.OauthDeviceCode{}, database.OauthClient{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get device code", err)
+		return database.OauthDeviceCode{}, database.OauthClient{}, false
+	}
+	client, err := cfg.db.GetOauthClient(r.Context(), code.ClientID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get OAuth client", err)
+		return database.OauthDeviceCode{}, database.OauthClient{}, false
+	}
+	return code, client, true
+}
+
+// describeDeviceHandler tells the device page which client a user code
+// belongs to and what it asks for.
+func (cfg *apiConfig) describeDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	code, client, ok := cfg.pendingDeviceCode(w, r, r.URL.Query().Get("user_code"))
+	if !ok {
+		return
+	}
+	type response struct {
+		ClientName string   `json:"client_name"`
+		Scopes     []string `json:"scopes"`
+		UserCode   string   `json:"user_code"`
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		ClientName: client.Name,
+		Scopes:     code.Scopes,
+		UserCode:   formatUserCode(code.UserCode),
+	})
+}
+
+// deviceConsentHandler records the logged-in user's answer for a user
+// code. The client picks it up on its next poll. Like consentHandler it
+// ends the session the page logged in with.
+func (cfg *apiConfig) deviceConsentHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	reqBody := struct {
+		UserCode string `json:"user_code"`
+		Approve  bool   `json:"approve"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	code, client, ok := cfg.pendingDeviceCode(w, r, reqBody.UserCode)
+	if !ok {
+		return
+	}
+	status := deviceCodeDenied
+	if reqBody.Approve {
+		status = deviceCodeApproved
+	}
+	decided, err := cfg.db.DecideOauthDeviceCode(r.Context(), database.DecideOauthDeviceCodeParams{
+		UserCode: code.UserCode,
+		Status:   status,
+		UserID:   uuid.NullUUID{UUID: userID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save your answer", err)
+		return
+	}
+	if decided == 0 {
+		respondWithError(w, http.StatusNotFound, "unknown or expired code", nil)
+		return
+	}
+	if reqBody.Approve {
+		cfg.audit(r, auditDeviceApproved, userID, userID, auditMeta{"client_id": client.ID, "scopes": code.Scopes})
+	}
+	cfg.endConsentSession(r)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/Ayannamdeo/chirpy/internal/mailer"
+	"github.com/Ayannamdeo/chirpy/internal/middleware"
+	"github.com/google/uuid"
+)
+
+const verifyEmailTTL = 24 * time.Hour
+
+// sendVerificationEmail mails user a link proving they own their address.
+// The token is bound to the address, so it stops working once the email
+// changes.
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	token, err := auth.MakePurposeToken(user.ID, user.Email, auth.PurposeVerifyEmail, cfg.jwtKeys, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	link := cfg.publicURL + "/app/verify-email.html?token=" + url.QueryEscape(token)
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Welcome to Chirpy!\n\n"+
+			"Open this link within 24 hours to verify your email address:\n\n%s\n\n"+
+			"If you didn't sign up for Chirpy, you can ignore this email.\n", link),
+	})
+}
+
+func (cfg *apiConfig) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody := struct {
+		Token string `json:"token"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	claims, err := auth.ParsePurposeToken(reqBody.Token, auth.PurposeVerifyEmail, cfg.jwtKeys, nil)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid or expired token", err)
+		return
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid token", err)
+		return
+	}
+	_, err = cfg.db.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+		ID:    userID,
+		Email: claims.Email,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+	// Nothing updated means the link was already used or the address has
+	// changed since; only the former is fine.
+	user, err := cfg.db.GetUserById(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid token", err)
+		return
+	}
+	if user.Email != claims.Email || !user.EmailVerifiedAt.Valid {
+		respondWithError(w, http.StatusBadRequest, "token is no longer valid", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, User{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		IsChirpyRed:   user.IsChirpyRed,
+		EmailVerified: true,
+	})
+}
+
+func (cfg *apiConfig) resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	user, err := cfg.db.GetUserById(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	if user.EmailVerifiedAt.Valid {
+		respondWithError(w, http.StatusConflict, "email is already verified", nil)
+		return
+	}
+	if err := cfg.sendVerificationEmail(r.Context(), user); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/middleware"
+	"github.com/google/uuid"
+)
+
+// impersonationTTL is kept short, and there is no refresh token: support
+// asks for a new token, and a new audit entry, when it runs out.
+const impersonationTTL = 15 * time.Minute
+
+// impersonateHandler gives an admin an access token for another user, so
+// support can see Chirpy as they do. The token names the admin in its act
+// claim and carries the admin's session, so it dies with it.
+func (cfg *apiConfig) impersonateHandler(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.FromContext(r.Context())
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID format", err)
+		return
+	}
+	reqBody := struct {
+		Reason string `json:"reason"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	reqBody.Reason = strings.TrimSpace(reqBody.Reason)
+	if reqBody.Reason == "" {
+		respondWithError(w, http.StatusBadRequest, "reason is required", nil)
+		return
+	}
+	if userID == principal.UserID {
+		respondWithError(w, http.StatusBadRequest, "you can't impersonate yourself", nil)
+		return
+	}
+	user, err := cfg.db.GetUserById(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	// admins answer for what they do under their own name
+	if slices.Contains(auth.Permissions(user.Role), auth.PermImpersonate) {
+		respondWithError(w, http.StatusForbidden, "admins can't be impersonated", nil)
+		return
+	}
+
+	sessionID, _ := uuid.Parse(principal.SessionID)
+	token, err := auth.MakeAccessToken(auth.AccessToken{
+		UserID:    user.ID,
+		SessionID: sessionID,
+		ChirpyRed: user.IsChirpyRed,
+		ActorID:   principal.UserID,
+	}, cfg.jwtKeys, impersonationTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't make impersonation token", err)
+		return
+	}
+	cfg.audit(r, auditImpersonationStarted, principal.UserID, user.ID, auditMeta{"reason": reqBody.Reason})
+
+	type response struct {
+		Token     string    `json:"token"`
+		ExpiresIn int       `json:"expires_in"`
+		UserID    uuid.UUID `json:"user_id"`
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Token:     token,
+		ExpiresIn: int(impersonationTTL.Seconds()),
+		UserID:    user.ID,
+	})
+}
+
+// logImpersonatedRequest is the Authenticator's OnImpersonation hook.
+func (cfg *apiConfig) logImpersonatedRequest(r *http.Request, p *middleware.Principal) {
+	cfg.audit(r, auditImpersonatedRequest, p.ActorID, p.UserID, auditMeta{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	})
+}
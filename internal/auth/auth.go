@@ -6,26 +6,66 @@ import (
   "errors"
   "strings"
   "log"
+  "crypto/hmac"
   "crypto/rand"
+  "crypto/sha256"
   "encoding/hex"
 	"github.com/google/uuid"
-  "golang.org/x/crypto/bcrypt"
   "github.com/golang-jwt/jwt/v5"
 )
 
-func HashPassword (password string) (string, error){
-  hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-  if err != nil {
-    return "", err
-  }
-  return string(hashedBytes), nil
+// Claims are the claims Chirpy puts in its access tokens. SessionID is the
+// refresh token family the token was minted from, empty for tokens that
+// don't belong to a session. Email binds purpose tokens sent by mail to the
+// address they were sent to. ClientID and Scope are only set on tokens
+// issued to OAuth clients, which may do no more than Scope allows. Role
+// and Permissions are only set on first-party tokens. They and ChirpyRed
+// are as they were when the token was minted, so handlers can check them
+// without asking the database. Actor is set when an admin is acting as
+// Subject.
+type Claims struct {
+  jwt.RegisteredClaims
+  SessionID string `json:"sid,omitempty"`
+  Email string `json:"email,omitempty"`
+  ClientID string `json:"client_id,omitempty"`
+  Scope string `json:"scope,omitempty"`
+  Role string `json:"role,omitempty"`
+  Permissions []string `json:"permissions,omitempty"`
+  ChirpyRed bool `json:"is_chirpy_red,omitempty"`
+  Actor *Actor `json:"act,omitempty"`
+}
+
+// Actor is the act claim of RFC 8693: who is really behind a token issued
+// for someone else.
+type Actor struct {
+  Subject string `json:"sub"`
 }
 
-func CheckPasswordHash (password, hash string) error {
-  return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+// Denylist reports whether an access token was revoked, either by its own
+// jti or through the session it belongs to.
+type Denylist interface {
+  IsDenied(jti, sessionID string) bool
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error){
+func MakeJWT(userID, sessionID uuid.UUID, keys *KeySet, expiresIn time.Duration) (string, error){
+  return MakeAccessToken(AccessToken{UserID: userID, SessionID: sessionID}, keys, expiresIn)
+}
+
+// AccessToken is what goes into an access token. Role is left out of
+// tokens for OAuth clients, which only get their scopes.
+// ActorID makes it an impersonation token, which never carries the
+// permissions of the user's role.
+type AccessToken struct {
+  UserID uuid.UUID
+  SessionID uuid.UUID
+  Role string
+  ChirpyRed bool
+  ClientID string
+  Scopes []string
+  ActorID uuid.UUID
+}
+
+func MakeAccessToken(token AccessToken, keys *KeySet, expiresIn time.Duration) (string, error){
   // log.Println("")
   // log.Println("current time:")
   // log.Println(jwt.NewNumericDate(time.Now()))
@@ -33,25 +73,80 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
   // log.Println("expiresIn :")
   // log.Println(jwt.NewNumericDate(time.Now().Add(expiresIn)))
   // log.Println("")
-  token := jwt.NewWithClaims(jwt.SigningMethodHS256,
-    jwt.RegisteredClaims{Issuer: "chirpy", Subject: userID.String(), IssuedAt:
+  opts := currentJWTOptions()
+  claims := Claims{
+    RegisteredClaims: jwt.RegisteredClaims{ID: uuid.NewString(), Issuer: opts.Issuer, Subject: token.UserID.String(),
+      Audience: jwt.ClaimStrings{opts.Audience}, IssuedAt:
       jwt.NewNumericDate(time.Now()), ExpiresAt:
-      jwt.NewNumericDate(time.Now().Add(expiresIn))})
-  signedToken, err := token.SignedString([]byte(tokenSecret))
+      jwt.NewNumericDate(time.Now().Add(expiresIn))},
+  }
+  if token.SessionID != uuid.Nil {
+    claims.SessionID = token.SessionID.String()
+  }
+  claims.ChirpyRed = token.ChirpyRed
+  if token.ClientID != "" {
+    claims.ClientID = token.ClientID
+    claims.Scope = strings.Join(token.Scopes, " ")
+  } else if token.ActorID != uuid.Nil {
+    claims.Actor = &Actor{Subject: token.ActorID.String()}
+  } else {
+    claims.Role = token.Role
+    claims.Permissions = Permissions(token.Role)
+  }
+  signedToken, err := keys.sign(claims)
   if err != nil {
     return "", err
   }
   return signedToken, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error){
-  keyFunc := func(token *jwt.Token) (interface{}, error) {
-    return []byte(tokenSecret), nil
+// ParseJWT validates the token and returns all of its claims. denylist may
+// be nil to skip the revocation check.
+func ParseJWT(tokenString string, keys *KeySet, denylist Denylist) (*Claims, error){
+  claims := Claims{}
+  // purpose tokens are signed with the same keys but must never pass as
+  // access tokens, the audience tells them apart
+  token, err := jwt.ParseWithClaims(tokenString, &claims, keys.keyFunc, keys.parserOptions(currentJWTOptions().Audience)...)
+  if errors.Is(err, jwt.ErrTokenRequiredClaimMissing) && len(claims.Audience) == 0 {
+    token, err = parseWithoutAudience(tokenString, keys, &claims)
   }
-  claims := jwt.RegisteredClaims{}
-  token, err := jwt.ParseWithClaims(tokenString, &claims, keyFunc)
   if err != nil || !token.Valid {
     log.Printf("parsewithclaims failed %v\n", err)
+    return nil, err
+  }
+  if claims.Subject == "" {
+    return nil, errors.New("token has no subject")
+  }
+  if denylist != nil && denylist.IsDenied(claims.ID, claims.SessionID) {
+    return nil, errors.New("token has been revoked")
+  }
+  return &claims, nil
+}
+
+// parseWithoutAudience accepts an access token signed before tokens had
+// an audience, if it was issued before MissingAudienceBefore and is
+// younger than MissingAudienceMaxAge.
+func parseWithoutAudience(tokenString string, keys *KeySet, claims *Claims) (*jwt.Token, error){
+  opts := currentJWTOptions()
+  if opts.MissingAudienceBefore.IsZero() || opts.MissingAudienceMaxAge <= 0 {
+    return nil, errors.New("token has no audience")
+  }
+  token, err := jwt.ParseWithClaims(tokenString, claims, keys.keyFunc, keys.parserOptions("")...)
+  if err != nil {
+    return nil, err
+  }
+  if claims.IssuedAt == nil || !claims.IssuedAt.Time.Before(opts.MissingAudienceBefore) {
+    return nil, errors.New("token has no audience")
+  }
+  if time.Since(claims.IssuedAt.Time) > opts.MissingAudienceMaxAge+opts.Leeway {
+    return nil, errors.New("token has no audience and is too old to be let through without one")
+  }
+  return token, nil
+}
+
+func ValidateJWT(tokenString string, keys *KeySet, denylist Denylist) (uuid.UUID, error){
+  claims, err := ParseJWT(tokenString, keys, denylist)
+  if err != nil {
     return uuid.UUID{}, err
   }
   userId, err := uuid.Parse(claims.Subject)
@@ -61,6 +156,43 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error){
   return userId, nil
 }
 
+// Purposes of the short-lived tokens that stand in for a step of a flow
+// rather than for the user. The purpose goes in the aud claim.
+const (
+  PurposeTwoFactor = "chirpy:2fa"
+  PurposeVerifyEmail = "chirpy:verify-email"
+  PurposeMagicLink = "chirpy:magic-link"
+)
+
+// MakePurposeToken signs a token for one step of a flow. email may be empty.
+func MakePurposeToken(userID uuid.UUID, email, purpose string, keys *KeySet, expiresIn time.Duration) (string, error){
+  return keys.sign(Claims{
+    Email: email,
+    RegisteredClaims: jwt.RegisteredClaims{
+      ID: uuid.NewString(),
+      Issuer: currentJWTOptions().Issuer,
+      Subject: userID.String(),
+      Audience: jwt.ClaimStrings{purpose},
+      IssuedAt: jwt.NewNumericDate(time.Now()),
+      ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+    },
+  })
+}
+
+// ParsePurposeToken validates a token made by MakePurposeToken for the
+// given purpose. Deny its jti afterwards to make it single-use.
+func ParsePurposeToken(tokenString, purpose string, keys *KeySet, denylist Denylist) (*Claims, error){
+  claims := Claims{}
+  _, err := jwt.ParseWithClaims(tokenString, &claims, keys.keyFunc, keys.parserOptions(purpose)...)
+  if err != nil {
+    return nil, err
+  }
+  if denylist != nil && denylist.IsDenied(claims.ID, "") {
+    return nil, errors.New("token has already been used")
+  }
+  return &claims, nil
+}
+
 func GetBearerToken(headers http.Header) (string, error){
   authHeader := headers.Get("Authorization")
   if authHeader == "" {
@@ -98,3 +230,28 @@ func MakeRefreshToken() (string, error){
   encodedStr := hex.EncodeToString(randData)
   return encodedStr, nil
 }
+
+// HashToken is how random bearer secrets (recovery codes, reset tokens)
+// are stored. They have enough entropy that a fast hash is fine.
+func HashToken(token string) string {
+  sum := sha256.Sum256([]byte(token))
+  return hex.EncodeToString(sum[:])
+}
+
+// HashRefreshToken is how refresh tokens are stored. The hash is keyed, so
+// a copy of the table is useless without the key, which lives outside the
+// database.
+func HashRefreshToken(token string, key []byte) string {
+  mac := hmac.New(sha256.New, key)
+  mac.Write([]byte(token))
+  return hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeriveKey turns a secret meant for something else into a key of its own
+// for purpose, so one configured secret can serve both without the keys
+// being related in any useful way.
+func DeriveKey(secret []byte, purpose string) []byte {
+  mac := hmac.New(sha256.New, secret)
+  mac.Write([]byte(purpose))
+  return mac.Sum(nil)
+}
@@ -0,0 +1,101 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const testSecret = "secret"
+
+func testKeys() *auth.KeySet {
+	keys := auth.NewKeySet()
+	keys.AddHMAC([]byte(testSecret))
+	return keys
+}
+
+// legacyToken is an access token the way Chirpy signed them before they
+// had an audience.
+func legacyToken(t *testing.T, issuedAt time.Time) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    "chirpy",
+		Subject:   uuid.NewString(),
+		IssuedAt:  jwt.NewNumericDate(issuedAt),
+		ExpiresAt: jwt.NewNumericDate(issuedAt.Add(time.Hour)),
+	}).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func setJWTOptions(t *testing.T, o auth.JWTOptions) {
+	t.Helper()
+	auth.SetJWTOptions(o)
+	t.Cleanup(func() { auth.SetJWTOptions(auth.DefaultJWTOptions) })
+}
+
+func TestParseJWTWithoutAudience(t *testing.T) {
+	keys := testKeys()
+	upgrade := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	o := auth.DefaultJWTOptions
+	o.MissingAudienceBefore = upgrade
+	o.MissingAudienceMaxAge = time.Hour
+	setJWTOptions(t, o)
+
+	tests := []struct {
+		name     string
+		issuedAt time.Time
+		ok       bool
+	}{
+		{"issued before the upgrade", upgrade.Add(-time.Minute), true},
+		{"issued after the upgrade", time.Now(), false},
+		{"issued right at the upgrade", upgrade, false},
+		{"older than the max age", upgrade.Add(-2 * time.Hour), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := auth.ParseJWT(legacyToken(t, tt.issuedAt), keys, nil)
+			if tt.ok && err != nil {
+				t.Errorf("ParseJWT: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("ParseJWT accepted the token")
+			}
+		})
+	}
+}
+
+func TestParseJWTWithoutAudienceFallbackOff(t *testing.T) {
+	keys := testKeys()
+	if _, err := auth.ParseJWT(legacyToken(t, time.Now().Add(-time.Minute)), keys, nil); err == nil {
+		t.Error("ParseJWT accepted a token without aud with no fallback set")
+	}
+}
+
+func TestParseJWTAudience(t *testing.T) {
+	keys := testKeys()
+	o := auth.DefaultJWTOptions
+	o.MissingAudienceBefore = time.Now()
+	o.MissingAudienceMaxAge = time.Hour
+	setJWTOptions(t, o)
+
+	accessToken, err := auth.MakeJWT(uuid.New(), uuid.New(), keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.ParseJWT(accessToken, keys, nil); err != nil {
+		t.Errorf("ParseJWT rejected an access token: %v", err)
+	}
+	purposeToken, err := auth.MakePurposeToken(uuid.New(), "", auth.PurposeMagicLink, keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.ParseJWT(purposeToken, keys, nil); err == nil {
+		t.Error("ParseJWT accepted a purpose token")
+	}
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTOptions are what Chirpy stamps on the tokens it signs and insists on
+// when it gets them back.
+type JWTOptions struct {
+	// Issuer is the iss of every token.
+	Issuer string
+	// Audience is the aud of access tokens. Purpose tokens have their
+	// purpose as audience instead, so neither passes for the other.
+	Audience string
+	// Leeway is how much clock skew is tolerated on exp, nbf and iat.
+	Leeway time.Duration
+	// MissingAudienceBefore is when audiences started being checked.
+	// Tokens signed before then have no aud; they are still let through
+	// as access tokens if they were issued before it and less than
+	// MissingAudienceMaxAge ago. With the longest access token TTL as max
+	// age, the fallback matches nothing once that long has passed since
+	// the upgrade. A zero time rejects them outright.
+	MissingAudienceBefore time.Time
+	MissingAudienceMaxAge time.Duration
+}
+
+var DefaultJWTOptions = JWTOptions{
+	Issuer:   "chirpy",
+	Audience: "chirpy-api",
+	Leeway:   30 * time.Second,
+}
+
+var (
+	jwtOptionsMu sync.RWMutex
+	jwtOptions   = DefaultJWTOptions
+)
+
+func SetJWTOptions(o JWTOptions) {
+	jwtOptionsMu.Lock()
+	defer jwtOptionsMu.Unlock()
+	jwtOptions = o
+}
+
+func currentJWTOptions() JWTOptions {
+	jwtOptionsMu.RLock()
+	defer jwtOptionsMu.RUnlock()
+	return jwtOptions
+}
+
+// JWTLeeway is how long after exp a token is still accepted, for whatever
+// has to remember a token until it can no longer be used.
+func JWTLeeway() time.Duration {
+	return currentJWTOptions().Leeway
+}
+
+// parserOptions are the checks every token goes through: the algorithms
+// of our own keys only, our issuer, audience, and an expiry that is there
+// and not past.
+func (ks *KeySet) parserOptions(audience string) []jwt.ParserOption {
+	o := currentJWTOptions()
+	return []jwt.ParserOption{
+		jwt.WithValidMethods(ks.methods()),
+		jwt.WithIssuer(o.Issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(o.Leeway),
+	}
+}
@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// legacyKID is the kid of the shared HS256 secret. Tokens signed with it
+// carry no kid header, which is what every token issued before key
+// rotation looks like.
+const legacyKID = ""
+
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	// signer is the key handed to jwt when signing, nil for keys that are
+	// only kept around to verify tokens issued before a rotation.
+	signer interface{}
+	// verifier is the key handed to jwt when validating.
+	verifier interface{}
+}
+
+// KeySet holds every key Chirpy accepts access tokens from, indexed by kid.
+// One of them is active and signs new tokens; the others only verify, so
+// tokens issued before a rotation stay valid until they expire.
+type KeySet struct {
+	mu     sync.RWMutex
+	keys   map[string]*signingKey
+	active string
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{keys: map[string]*signingKey{}}
+}
+
+// AddHMAC registers the legacy shared secret. It only becomes the active
+// key when no asymmetric key has been configured.
+func (ks *KeySet) AddHMAC(secret []byte) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[legacyKID] = &signingKey{
+		kid:      legacyKID,
+		method:   jwt.SigningMethodHS256,
+		signer:   secret,
+		verifier: secret,
+	}
+	if _, ok := ks.keys[ks.active]; !ok {
+		ks.active = legacyKID
+	}
+}
+
+// AddKey registers an RSA or Ed25519 key under kid. Private keys can sign,
+// public keys are verify-only.
+func (ks *KeySet) AddKey(kid string, key interface{}) error {
+	if kid == legacyKID {
+		return errors.New("kid must not be empty")
+	}
+	k, err := newSigningKey(kid, key)
+	if err != nil {
+		return err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[kid] = k
+	return nil
+}
+
+// SetActive switches signing over to kid. The previously active key stays
+// in the set for verification.
+func (ks *KeySet) SetActive(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	k, ok := ks.keys[kid]
+	if !ok {
+		return fmt.Errorf("unknown kid %q", kid)
+	}
+	if k.signer == nil {
+		return fmt.Errorf("key %q is verify-only", kid)
+	}
+	ks.active = kid
+	return nil
+}
+
+// LoadDir replaces the asymmetric keys with every *.pem file in dir, using
+// the file name without extension as kid. activeKID picks the signing key;
+// when it is empty the greatest kid that holds a private key wins, so keys
+// named by date rotate simply by dropping a newer file in the directory.
+// The legacy HMAC secret, if any, is kept.
+func (ks *KeySet) LoadDir(dir, activeKID string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	keys := map[string]*signingKey{}
+	signers := []string{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		key, err := ParseKeyPEM(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		k, err := newSigningKey(kid, key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		keys[kid] = k
+		if k.signer != nil {
+			signers = append(signers, kid)
+		}
+	}
+	if activeKID == "" && len(signers) > 0 {
+		sort.Strings(signers)
+		activeKID = signers[len(signers)-1]
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if legacy, ok := ks.keys[legacyKID]; ok {
+		keys[legacyKID] = legacy
+	}
+	if activeKID == "" {
+		if _, ok := keys[legacyKID]; !ok {
+			return fmt.Errorf("no signing key found in %s", dir)
+		}
+		activeKID = legacyKID
+	}
+	if k, ok := keys[activeKID]; !ok || k.signer == nil {
+		return fmt.Errorf("active kid %q has no private key in %s", activeKID, dir)
+	}
+	ks.keys = keys
+	ks.active = activeKID
+	return nil
+}
+
+func (ks *KeySet) activeKey() (*signingKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	k, ok := ks.keys[ks.active]
+	if !ok {
+		return nil, errors.New("no signing key configured")
+	}
+	return k, nil
+}
+
+func (ks *KeySet) lookup(kid string) (*signingKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	k, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	return k, nil
+}
+
+// keyFunc resolves the verification key from the token's kid header and
+// refuses tokens whose alg doesn't match the key, so a public key can never
+// be used as an HMAC secret.
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	k, err := ks.lookup(kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for kid %q", token.Method.Alg(), kid)
+	}
+	return k.verifier, nil
+}
+
+// methods lists the algorithms of the keys in the set, the only ones a
+// token may be signed with.
+func (ks *KeySet) methods() []string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	methods := []string{}
+	for _, k := range ks.keys {
+		if !slices.Contains(methods, k.method.Alg()) {
+			methods = append(methods, k.method.Alg())
+		}
+	}
+	return methods
+}
+
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	k, err := ks.activeKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(k.method, claims)
+	if k.kid != legacyKID {
+		token.Header["kid"] = k.kid
+	}
+	return token.SignedString(k.signer)
+}
+
+func newSigningKey(kid string, key interface{}) (*signingKey, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, signer: key, verifier: &key.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, verifier: key}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, signer: key, verifier: key.Public()}, nil
+	case ed25519.PublicKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, verifier: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, want RSA or Ed25519", key)
+	}
+}
+
+// ParseKeyPEM reads a PKCS#8/PKCS#1 private key or a PKIX/PKCS#1 public key.
+func ParseKeyPEM(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public half of every asymmetric key, including
+// verify-only ones, sorted by kid. The HMAC secret is never published.
+func (ks *KeySet) JWKS() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range ks.keys {
+		jwk := JWK{Kid: k.kid, Use: "sig", Alg: k.method.Alg()}
+		switch pub := k.verifier.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+	return set
+}
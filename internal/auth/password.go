@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrPasswordMismatch = errors.New("password does not match hash")
+
+// Argon2idParams are the cost parameters new password hashes are created
+// with. They are encoded in every hash, so raising them later only affects
+// new hashes and NeedsRehash picks out the old ones.
+type Argon2idParams struct {
+	// Memory is in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams follow the OWASP recommendation for argon2id.
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var (
+	paramsMu       sync.RWMutex
+	passwordParams = DefaultArgon2idParams
+)
+
+// Validate rejects parameters argon2.IDKey would panic on.
+func (p Argon2idParams) Validate() error {
+	if p.Iterations < 1 {
+		return errors.New("argon2id iterations must be at least 1")
+	}
+	if p.Parallelism < 1 {
+		return errors.New("argon2id parallelism must be at least 1")
+	}
+	if p.Memory < 8*uint32(p.Parallelism) {
+		return fmt.Errorf("argon2id memory must be at least 8 KiB per lane, %d KiB", 8*uint32(p.Parallelism))
+	}
+	return nil
+}
+
+func SetArgon2idParams(p Argon2idParams) {
+	paramsMu.Lock()
+	defer paramsMu.Unlock()
+	passwordParams = p
+}
+
+func currentParams() Argon2idParams {
+	paramsMu.RLock()
+	defer paramsMu.RUnlock()
+	return passwordParams
+}
+
+// HashPassword returns a PHC-formatted argon2id hash:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func HashPassword(password string) (string, error) {
+	p := currentParams()
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// CheckPasswordHash accepts argon2id hashes and the bcrypt hashes stored
+// before argon2id was introduced.
+func CheckPasswordHash(password, hash string) error {
+	if isBcrypt(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordMismatch
+		}
+		return err
+	}
+	p, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// NeedsRehash reports whether hash was made with an older algorithm or
+// weaker parameters than HashPassword currently uses. Only call it after
+// CheckPasswordHash succeeded, since rehashing needs the plain password.
+func NeedsRehash(hash string) bool {
+	if isBcrypt(hash) {
+		return true
+	}
+	p, _, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	want := currentParams()
+	return p.Memory < want.Memory ||
+		p.Iterations < want.Iterations ||
+		p.Parallelism < want.Parallelism ||
+		p.SaltLength < want.SaltLength ||
+		p.KeyLength < want.KeyLength
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func decodeArgon2id(hash string) (Argon2idParams, []byte, []byte, error) {
+	p := Argon2idParams{}
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, errors.New("unsupported password hash format")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, err
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	if err := p.Validate(); err != nil {
+		return p, nil, nil, err
+	}
+	// an empty key would match every password
+	if len(key) == 0 {
+		return p, nil, nil, errors.New("password hash has no key")
+	}
+	return p, salt, key, nil
+}
@@ -0,0 +1,38 @@
+package auth
+
+import "slices"
+
+// Roles a user can have. Every account starts out as RoleUser.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permissions guard admin and moderation endpoints. Unlike scopes, which
+// narrow what a token may do for its user, they grant powers over other
+// users' data.
+const (
+	PermViewMetrics    = "admin:metrics"
+	PermResetData      = "admin:reset"
+	PermManageRoles    = "admin:roles"
+	PermViewAudit      = "admin:audit"
+	PermImpersonate    = "admin:impersonate"
+	PermModerateChirps = "moderate:chirps"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser:      {},
+	RoleModerator: {PermModerateChirps},
+	RoleAdmin:     {PermViewMetrics, PermResetData, PermManageRoles, PermViewAudit, PermImpersonate, PermModerateChirps},
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Permissions returns the permissions role grants, none for unknown roles.
+func Permissions(role string) []string {
+	return slices.Clone(rolePermissions[role])
+}
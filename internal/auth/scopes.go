@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"strings"
+)
+
+// Scopes limit what a personal access token may do. Access tokens from a
+// login carry no scopes and may do everything the user can. Reading chirps
+// is public for now, so chirps:read only matters once that changes.
+const (
+	ScopeChirpsRead  = "chirps:read"
+	ScopeChirpsWrite = "chirps:write"
+	ScopeUsersWrite  = "users:write"
+)
+
+var Scopes = []string{
+	ScopeChirpsRead,
+	ScopeChirpsWrite,
+	ScopeUsersWrite,
+}
+
+func ValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// APITokenPrefix marks personal access tokens so they are easy to tell
+// apart from other secrets, e.g. by secret scanners.
+const APITokenPrefix = "chirpy_pat_"
+
+func MakeAPIToken() (string, error) {
+	randData := make([]byte, 32)
+	if _, err := rand.Read(randData); err != nil {
+		return "", err
+	}
+	return APITokenPrefix + hex.EncodeToString(randData), nil
+}
+
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
@@ -6,11 +6,42 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type AccessTokenDenylist struct {
+	Kind      string
+	ID        uuid.UUID
+	RevokedAt time.Time
+	ExpiresAt time.Time
+}
+
+type ApiToken struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+type AuditEvent struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	EventType string
+	ActorID   uuid.NullUUID
+	UserID    uuid.NullUUID
+	IpAddress string
+	UserAgent string
+	Metadata  json.RawMessage
+}
+
 type Chirp struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
@@ -19,20 +50,140 @@ type Chirp struct {
 	UserID    uuid.UUID
 }
 
-type RefreshToken struct {
-	Token     string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+type FailedAttempt struct {
+	Key           string
+	Failures      int32
+	LastFailureAt time.Time
+	LockedUntil   sql.NullTime
+}
+
+type MagicLinkUse struct {
+	Jti       uuid.UUID
+	UsedAt    time.Time
+	ExpiresAt time.Time
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scopes        []string
+	CodeChallenge string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type OauthClient struct {
+	ID           string
+	UserID       uuid.UUID
+	Name         string
+	SecretHash   sql.NullString
+	RedirectUris []string
+	Scopes       []string
+	CreatedAt    time.Time
+}
+
+type OauthDeviceCode struct {
+	DeviceCodeHash string
+	UserCode       string
+	ClientID       string
+	Scopes         []string
+	Status         string
+	UserID         uuid.NullUUID
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+	LastPolledAt   sql.NullTime
+	UsedAt         sql.NullTime
+}
+
+type OidcLoginState struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	DeviceLabel  string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+type PasswordResetToken struct {
+	TokenHash string
 	UserID    uuid.UUID
+	CreatedAt time.Time
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	UsedAt    sql.NullTime
+}
+
+type RecoveryCode struct {
+	CodeHash  string
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type RefreshToken struct {
+	TokenHash        string
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	UserID           uuid.UUID
+	ExpiresAt        time.Time
+	RevokedAt        sql.NullTime
+	FamilyID         uuid.UUID
+	ParentTokenHash  sql.NullString
+	RotatedAt        sql.NullTime
+	SessionStartedAt time.Time
+	UserAgent        string
+	IpAddress        string
+	DeviceLabel      string
+	ClientID         sql.NullString
+	Scopes           []string
+	Hashed           bool
+}
+
+type TotpSecret struct {
+	UserID       uuid.UUID
+	Secret       string
+	ConfirmedAt  sql.NullTime
+	LastUsedStep int64
+	CreatedAt    time.Time
 }
 
 type User struct {
-	ID             uuid.UUID
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    bool
+	ID              uuid.UUID
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Email           string
+	HashedPassword  string
+	IsChirpyRed     bool
+	EmailVerifiedAt sql.NullTime
+	Role            string
+	DeleteAfter     sql.NullTime
+}
+
+type UserIdentity struct {
+	Issuer    string
+	Subject   string
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+type WebauthnChallenge struct {
+	Challenge string
+	Purpose   string
+	UserID    uuid.NullUUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type WebauthnCredential struct {
+	ID           uuid.UUID
+	UserID       uuid.UUID
+	CredentialID []byte
+	PublicKey    []byte
+	SignCount    int64
+	Transports   []string
+	Name         string
+	CreatedAt    time.Time
+	LastUsedAt   sql.NullTime
 }
@@ -0,0 +1,135 @@
+// Package denylist keeps track of access tokens that were revoked before
+// they expired. Entries live in Postgres so every instance sees them, and
+// each instance mirrors them in memory so validating a token never needs a
+// database round trip.
+package denylist
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	kindToken   = "jti"
+	kindSession = "sid"
+)
+
+// syncOverlap re-reads a little history on every sync so rows whose
+// revoked_at was stamped by a transaction that committed late aren't missed.
+const syncOverlap = 5 * time.Second
+
+type Store struct {
+	db *database.Queries
+	// tokenTTL is the longest an access token can live, and so how long a
+	// revoked session has to stay on the list.
+	tokenTTL time.Duration
+
+	mu      sync.RWMutex
+	entries map[string]time.Time
+	cursor  time.Time
+}
+
+func New(db *database.Queries, tokenTTL time.Duration) *Store {
+	return &Store{
+		db:       db,
+		tokenTTL: tokenTTL,
+		entries:  map[string]time.Time{},
+	}
+}
+
+// DenyToken revokes a single access token until it expires.
+func (s *Store) DenyToken(ctx context.Context, jti uuid.UUID, expiresAt time.Time) error {
+	return s.deny(ctx, kindToken, jti, expiresAt)
+}
+
+// DenySession revokes every access token minted from a refresh token family.
+func (s *Store) DenySession(ctx context.Context, sessionID uuid.UUID) error {
+	return s.deny(ctx, kindSession, sessionID, time.Now().UTC().Add(s.tokenTTL))
+}
+
+func (s *Store) deny(ctx context.Context, kind string, id uuid.UUID, expiresAt time.Time) error {
+	err := s.db.DenyAccessTokens(ctx, database.DenyAccessTokensParams{
+		Kind:      kind,
+		ID:        id,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key(kind, id.String())] = expiresAt
+	return nil
+}
+
+func (s *Store) IsDenied(jti, sessionID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now().UTC()
+	if exp, ok := s.entries[key(kindToken, jti)]; ok && now.Before(exp) {
+		return true
+	}
+	if sessionID == "" {
+		return false
+	}
+	exp, ok := s.entries[key(kindSession, sessionID)]
+	return ok && now.Before(exp)
+}
+
+// Sync pulls entries other instances added since the last sync and drops
+// the ones that have expired.
+func (s *Store) Sync(ctx context.Context) error {
+	s.mu.RLock()
+	since := s.cursor.Add(-syncOverlap)
+	s.mu.RUnlock()
+
+	rows, err := s.db.ListAccessTokenDenylistSince(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, row := range rows {
+		s.entries[key(row.Kind, row.ID.String())] = row.ExpiresAt
+		if row.RevokedAt.After(s.cursor) {
+			s.cursor = row.RevokedAt
+		}
+	}
+	now := time.Now().UTC()
+	for k, exp := range s.entries {
+		if !now.Before(exp) {
+			delete(s.entries, k)
+		}
+	}
+	return nil
+}
+
+// Run syncs every interval until ctx is done, and clears expired rows from
+// the table while it is at it.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Sync(ctx); err != nil {
+				log.Printf("Error syncing access token denylist: %s", err)
+			}
+			if err := s.db.DeleteExpiredAccessTokenDenylist(ctx); err != nil {
+				log.Printf("Error pruning access token denylist: %s", err)
+			}
+		}
+	}
+}
+
+func key(kind, id string) string {
+	return kind + ":" + id
+}
@@ -0,0 +1,131 @@
+// Package mailer sends account email (verification links, password
+// resets, sign-in links). Production uses SMTP; dev and tests can write
+// messages to a directory or keep them in memory instead.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// render formats msg as a plain text RFC 5322 message.
+func render(from string, msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// validate refuses header injection through the recipient or subject.
+func validate(msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("mailer: newline in header")
+	}
+	return nil
+}
+
+type SMTP struct {
+	// Addr is host:port of the relay.
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, render(m.From, msg))
+}
+
+// File writes every message to Dir as an .eml file, which any mail client
+// opens.
+type File struct {
+	Dir  string
+	From string
+}
+
+func (m *File) Send(ctx context.Context, msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000"), hex.EncodeToString(suffix))
+	return os.WriteFile(filepath.Join(m.Dir, name), render(m.From, msg), 0o600)
+}
+
+// Memory keeps messages in an outbox so tests can read them back.
+type Memory struct {
+	mu     sync.Mutex
+	outbox []Message
+}
+
+func (m *Memory) Send(ctx context.Context, msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outbox = append(m.outbox, msg)
+	return nil
+}
+
+// Outbox returns every message sent so far, oldest first.
+func (m *Memory) Outbox() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.outbox...)
+}
+
+// Last returns the newest message sent to addr.
+func (m *Memory) Last(addr string) (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.outbox) - 1; i >= 0; i-- {
+		if m.outbox[i].To == addr {
+			return m.outbox[i], true
+		}
+	}
+	return Message{}, false
+}
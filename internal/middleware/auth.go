@@ -0,0 +1,285 @@
+// Package middleware resolves who is calling the API once per request and
+// puts them in the request context as a Principal. Routes say who may call
+// them when they are registered, so handlers never parse credentials.
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Kind is how a caller proved who they are.
+type Kind int
+
+const (
+	// KindLogin is an access token from one of Chirpy's own logins.
+	KindLogin Kind = iota + 1
+	// KindOAuthClient is an access token issued to a third-party client.
+	KindOAuthClient
+	// KindAPIToken is a personal access token.
+	KindAPIToken
+)
+
+type Principal struct {
+	UserID uuid.UUID
+	Kind   Kind
+	// SessionID is the refresh token family of an access token, empty for
+	// API tokens and access tokens minted before sessions existed.
+	SessionID string
+	// ClientID is set for KindOAuthClient.
+	ClientID string
+	// APITokenID is set for KindAPIToken.
+	APITokenID uuid.UUID
+	// Scopes is nil for KindLogin, which may do anything.
+	Scopes []string
+	// Role and Permissions are only set for KindLogin: neither OAuth
+	// clients nor API tokens inherit their user's admin powers.
+	Role        string
+	Permissions []string
+	// ChirpyRed is whether the user had Chirpy Red when the access token
+	// was minted. It is never set for KindAPIToken.
+	ChirpyRed bool
+	// ActorID is the admin acting as UserID through an impersonation
+	// token, uuid.Nil otherwise.
+	ActorID uuid.UUID
+	// Claims is nil for KindAPIToken.
+	Claims *auth.Claims
+}
+
+// HasScope reports whether the principal may be used for scope.
+func (p *Principal) HasScope(scope string) bool {
+	if p.Kind == KindLogin {
+		return true
+	}
+	return slices.Contains(p.Scopes, scope)
+}
+
+func (p *Principal) HasPermission(permission string) bool {
+	return p.Kind == KindLogin && slices.Contains(p.Permissions, permission)
+}
+
+func (p *Principal) Impersonated() bool {
+	return p.ActorID != uuid.Nil
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the caller of a request that went through one of
+// the Authenticator's Require functions, nil otherwise.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// APITokenStore looks up personal access tokens. *database.Queries is one.
+type APITokenStore interface {
+	GetApiTokenByHash(ctx context.Context, tokenHash string) (database.ApiToken, error)
+	TouchApiToken(ctx context.Context, id uuid.UUID) error
+}
+
+type Authenticator struct {
+	Keys      *auth.KeySet
+	Denylist  auth.Denylist
+	APITokens APITokenStore
+	// RespondWithError writes error responses, so they look like the rest
+	// of the API's.
+	RespondWithError func(w http.ResponseWriter, code int, msg string, err error)
+	// OnImpersonation, if set, is called for every request made with an
+	// impersonation token, whether or not it is let through.
+	OnImpersonation func(r *http.Request, p *Principal)
+}
+
+var errNoCredentials = errors.New("no credentials")
+
+// authError is a failed authentication that is the caller's fault.
+type authError struct {
+	msg string
+	err error
+}
+
+func (e *authError) Error() string {
+	if e.err != nil {
+		return e.msg + ": " + e.err.Error()
+	}
+	return e.msg
+}
+
+// Authenticate resolves the caller of r: a personal access token sent as
+// "Authorization: ApiKey ...", an access token sent as
+// "Authorization: Bearer ..." or, without an Authorization header, the
+// access token cookie.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	switch {
+	case header == "":
+		cookie, err := r.Cookie(AccessTokenCookie)
+		if err != nil {
+			return nil, errNoCredentials
+		}
+		if err := CheckCSRF(r); err != nil {
+			return nil, err
+		}
+		claims, err := auth.ParseJWT(cookie.Value, a.Keys, a.Denylist)
+		if err != nil {
+			return nil, &authError{msg: "Couldn't validate jwt", err: err}
+		}
+		return claimsPrincipal(claims)
+	case strings.HasPrefix(header, "ApiKey "):
+		apiKey, err := auth.GetAPIKey(r.Header)
+		if err != nil {
+			return nil, &authError{msg: "invalid Authorization header", err: err}
+		}
+		return a.apiTokenPrincipal(r.Context(), apiKey)
+	case strings.HasPrefix(header, "Bearer "):
+		accessToken, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			return nil, &authError{msg: "invalid Authorization header", err: err}
+		}
+		claims, err := auth.ParseJWT(accessToken, a.Keys, a.Denylist)
+		if err != nil {
+			return nil, &authError{msg: "Couldn't validate jwt", err: err}
+		}
+		return claimsPrincipal(claims)
+	default:
+		return nil, &authError{msg: "unsupported Authorization scheme"}
+	}
+}
+
+func claimsPrincipal(claims *auth.Claims) (*Principal, error) {
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, &authError{msg: "Couldn't validate jwt", err: err}
+	}
+	p := &Principal{
+		UserID:    userID,
+		Kind:      KindLogin,
+		SessionID: claims.SessionID,
+		ChirpyRed: claims.ChirpyRed,
+		Claims:    claims,
+	}
+	switch {
+	case claims.ClientID != "":
+		p.Kind = KindOAuthClient
+		p.ClientID = claims.ClientID
+		p.Scopes = strings.Fields(claims.Scope)
+	case claims.Actor != nil:
+		actorID, err := uuid.Parse(claims.Actor.Subject)
+		if err != nil {
+			return nil, &authError{msg: "Couldn't validate jwt", err: err}
+		}
+		p.ActorID = actorID
+	default:
+		p.Role = claims.Role
+		p.Permissions = claims.Permissions
+	}
+	return p, nil
+}
+
+func (a *Authenticator) apiTokenPrincipal(ctx context.Context, apiKey string) (*Principal, error) {
+	token, err := a.APITokens.GetApiTokenByHash(ctx, auth.HashToken(apiKey))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &authError{msg: "invalid or expired API token", err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := a.APITokens.TouchApiToken(ctx, token.ID); err != nil {
+		log.Printf("Error updating last use of API token %s: %v", token.ID, err)
+	}
+	return &Principal{
+		UserID:     token.UserID,
+		Kind:       KindAPIToken,
+		APITokenID: token.ID,
+		Scopes:     token.Scopes,
+	}, nil
+}
+
+// require authenticates the request, lets allow decide whether the caller
+// may go on and answers 401/403 itself otherwise.
+func (a *Authenticator) require(next http.HandlerFunc, allow func(*Principal) (bool, string)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := a.Authenticate(r)
+		if err != nil {
+			var authErr *authError
+			switch {
+			case errors.Is(err, ErrCSRF):
+				a.RespondWithError(w, http.StatusForbidden, err.Error(), nil)
+			case errors.Is(err, errNoCredentials):
+				w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy"`)
+				a.RespondWithError(w, http.StatusUnauthorized, "authentication required", nil)
+			case errors.As(err, &authErr):
+				w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy", error="invalid_token"`)
+				a.RespondWithError(w, http.StatusUnauthorized, authErr.msg, authErr.err)
+			default:
+				a.RespondWithError(w, http.StatusInternalServerError, "Couldn't authenticate request", err)
+			}
+			return
+		}
+		if p.Impersonated() && a.OnImpersonation != nil {
+			a.OnImpersonation(r, p)
+		}
+		if ok, msg := allow(p); !ok {
+			a.RespondWithError(w, http.StatusForbidden, msg, nil)
+			return
+		}
+		next(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
+
+// RequireScope lets through any caller allowed to act for scope: logins,
+// and OAuth clients and API tokens that were granted it.
+func (a *Authenticator) RequireScope(scope string, next http.HandlerFunc) http.Handler {
+	return a.require(next, func(p *Principal) (bool, string) {
+		if !p.HasScope(scope) {
+			return false, "missing the " + scope + " scope"
+		}
+		return true, ""
+	})
+}
+
+// RequireLogin only lets through Chirpy's own logins. Account security,
+// sessions and credentials are off limits to API tokens and OAuth clients
+// whatever their scopes.
+func (a *Authenticator) RequireLogin(next http.HandlerFunc) http.Handler {
+	return a.require(next, func(p *Principal) (bool, string) {
+		if p.Kind != KindLogin {
+			return false, "only available when logged in to Chirpy"
+		}
+		return true, ""
+	})
+}
+
+// RequirePermission only lets through logins whose role grants permission.
+func (a *Authenticator) RequirePermission(permission string, next http.HandlerFunc) http.Handler {
+	return a.require(next, func(p *Principal) (bool, string) {
+		if !p.HasPermission(permission) {
+			return false, "missing the " + permission + " permission"
+		}
+		return true, ""
+	})
+}
+
+// NotImpersonated goes inside one of the Require functions and turns away
+// admins impersonating the user, for changes only the user may make.
+func (a *Authenticator) NotImpersonated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p := FromContext(r.Context()); p == nil || p.Impersonated() {
+			a.RespondWithError(w, http.StatusForbidden, "not available while impersonating a user", nil)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,131 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/oidc"
+	"github.com/Ayannamdeo/chirpy/internal/oidc/oidctest"
+)
+
+const testRedirectURL = "http://localhost:8080/app/oidc-callback.html"
+
+func newTestProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	t.Helper()
+	idp, err := oidctest.NewServer("chirpy", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(idp.Close)
+	p, err := oidc.Discover(context.Background(), idp.Issuer, idp.ClientID, idp.ClientSecret, testRedirectURL)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	return idp, p
+}
+
+// authorize follows AuthCodeURL to the provider and returns the code and
+// state it redirects back with.
+func authorize(t *testing.T, p *oidc.Provider, state, nonce, verifier string) (string, string) {
+	t.Helper()
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(p.AuthCodeURL(state, nonce, auth.PKCEChallenge(verifier)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize answered %s", resp.Status)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(location.String(), testRedirectURL) {
+		t.Fatalf("redirected to %s", location)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestLogin(t *testing.T) {
+	_, p := newTestProvider(t)
+	code, state := authorize(t, p, "state", "nonce", "verifier")
+	if state != "state" {
+		t.Fatalf("state = %q, want %q", state, "state")
+	}
+	rawIDToken, err := p.Exchange(context.Background(), code, "verifier")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	claims, err := p.Verify(context.Background(), rawIDToken, "nonce")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims.Subject != "oidctest-user" || claims.Email != "sso-user@example.com" || !claims.EmailVerified {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if _, err := p.Exchange(context.Background(), code, "verifier"); err == nil {
+		t.Error("Exchange accepted a used code")
+	}
+}
+
+func TestWrongCodeVerifier(t *testing.T) {
+	_, p := newTestProvider(t)
+	code, _ := authorize(t, p, "state", "nonce", "verifier")
+	if _, err := p.Exchange(context.Background(), code, "another verifier"); err == nil {
+		t.Error("Exchange accepted the wrong code verifier")
+	}
+}
+
+func TestNonceMismatch(t *testing.T) {
+	_, p := newTestProvider(t)
+	code, _ := authorize(t, p, "state", "nonce", "verifier")
+	rawIDToken, err := p.Exchange(context.Background(), code, "verifier")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if _, err := p.Verify(context.Background(), rawIDToken, "another nonce"); err == nil {
+		t.Error("Verify accepted the wrong nonce")
+	}
+	if _, err := p.Verify(context.Background(), rawIDToken, ""); err == nil {
+		t.Error("Verify accepted an empty nonce")
+	}
+}
+
+func TestWrongAudience(t *testing.T) {
+	idp, p := newTestProvider(t)
+	code, _ := authorize(t, p, "state", "nonce", "verifier")
+	rawIDToken, err := p.Exchange(context.Background(), code, "verifier")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	other, err := oidc.Discover(context.Background(), idp.Issuer, "another-client", "", testRedirectURL)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	if _, err := other.Verify(context.Background(), rawIDToken, "nonce"); err == nil {
+		t.Error("Verify accepted a token for another client")
+	}
+}
+
+func TestExpiredIDToken(t *testing.T) {
+	idp, p := newTestProvider(t)
+	idp.IDTokenTTL = -time.Hour
+	code, _ := authorize(t, p, "state", "nonce", "verifier")
+	rawIDToken, err := p.Exchange(context.Background(), code, "verifier")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if _, err := p.Verify(context.Background(), rawIDToken, "nonce"); err == nil {
+		t.Error("Verify accepted an expired token")
+	}
+}
@@ -0,0 +1,253 @@
+// Package oidctest is a mock OpenID Connect provider for trying out and
+// testing OIDC login without a real identity provider. It logs everyone in
+// as User straight away, with no login page.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "oidctest"
+
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type grant struct {
+	redirectURI string
+	nonce       string
+	challenge   string
+	user        User
+}
+
+// Server implements discovery, authorize, token and JWKS endpoints for a
+// single client.
+type Server struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// IDTokenTTL is how long ID tokens are valid. Make it negative to
+	// hand out expired ones.
+	IDTokenTTL time.Duration
+
+	key *rsa.PrivateKey
+	mux *http.ServeMux
+
+	mu     sync.Mutex
+	user   User
+	grants map[string]grant
+
+	httpServer *httptest.Server
+}
+
+// New makes a provider that will be served at issuer, e.g. with
+// http.ListenAndServe. An empty clientSecret makes it a public client.
+func New(issuer, clientID, clientSecret string) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		IDTokenTTL:   5 * time.Minute,
+		key:          key,
+		mux:          http.NewServeMux(),
+		grants:       map[string]grant{},
+		user: User{
+			Subject:       "oidctest-user",
+			Email:         "sso-user@example.com",
+			EmailVerified: true,
+			Name:          "SSO User",
+		},
+	}
+	s.mux.HandleFunc("GET /.well-known/openid-configuration", s.discovery)
+	s.mux.HandleFunc("GET /authorize", s.authorize)
+	s.mux.HandleFunc("POST /token", s.token)
+	s.mux.HandleFunc("GET /jwks", s.jwks)
+	return s, nil
+}
+
+// NewServer starts a provider on a random local port. Close it when done.
+func NewServer(clientID, clientSecret string) (*Server, error) {
+	httpServer := httptest.NewUnstartedServer(nil)
+	s, err := New("", clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	httpServer.Config.Handler = s
+	httpServer.Start()
+	s.Issuer = httpServer.URL
+	s.httpServer = httpServer
+	return s, nil
+}
+
+func (s *Server) Close() {
+	if s.httpServer != nil {
+		s.httpServer.Close()
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// SetUser changes who the next logins are for.
+func (s *Server) SetUser(user User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = user
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, oauthErr string) {
+	writeJSON(w, code, map[string]string{"error": oauthErr})
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.Issuer,
+		"authorization_endpoint":                s.Issuer + "/authorize",
+		"token_endpoint":                        s.Issuer + "/token",
+		"jwks_uri":                              s.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != s.ClientID || query.Get("response_type") != "code" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectURI.Host == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	code := make([]byte, 16)
+	if _, err := rand.Read(code); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.mu.Lock()
+	s.grants[hex.EncodeToString(code)] = grant{
+		redirectURI: redirectURI.String(),
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+		user:        s.user,
+	}
+	s.mu.Unlock()
+	params := redirectURI.Query()
+	params.Set("code", hex.EncodeToString(code))
+	params.Set("state", query.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+	if clientID != s.ClientID || subtle.ConstantTimeCompare([]byte(secret), []byte(s.ClientSecret)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	code := r.PostFormValue("code")
+	s.mu.Lock()
+	g, ok := s.grants[code]
+	delete(s.grants, code)
+	s.mu.Unlock()
+	if !ok || g.redirectURI != r.PostFormValue("redirect_uri") {
+		writeError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	if g.challenge != "" {
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+			writeError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            s.Issuer,
+		"sub":            g.user.Subject,
+		"aud":            s.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(s.IDTokenTTL).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.user.Email,
+		"email_verified": g.user.EmailVerified,
+		"name":           g.user.Name,
+	})
+	token.Header["kid"] = keyID
+	idToken, err := token.SignedString(s.key)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	accessToken := make([]byte, 16)
+	rand.Read(accessToken)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": hex.EncodeToString(accessToken),
+		"token_type":   "Bearer",
+		"expires_in":   int(max(s.IDTokenTTL, 0).Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
@@ -0,0 +1,130 @@
+// Package throttle counts failed attempts per key (an email, a client IP)
+// in Postgres and locks the key out with exponential backoff once too many
+// pile up. Counters survive restarts and are shared between instances.
+package throttle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/database"
+)
+
+type Policy struct {
+	// FreeAttempts is how many failures are allowed before the first lockout.
+	FreeAttempts int
+	// BaseLockout is the first lockout; every further failure doubles it.
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	// Window is how long a failure is remembered. A failure after a quiet
+	// Window starts counting from one again.
+	Window time.Duration
+}
+
+type Limiter struct {
+	db     *database.Queries
+	prefix string
+	policy Policy
+}
+
+// New returns a limiter whose keys are stored as prefix + ":" + key, so
+// several limiters can share the table.
+func New(db *database.Queries, prefix string, policy Policy) *Limiter {
+	return &Limiter{
+		db:     db,
+		prefix: prefix + ":",
+		policy: policy,
+	}
+}
+
+// Check returns how long key is still locked out, zero when it isn't.
+func (l *Limiter) Check(ctx context.Context, key string) (time.Duration, error) {
+	attempts, err := l.db.GetFailedAttempts(ctx, l.prefix+key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !attempts.LockedUntil.Valid {
+		return 0, nil
+	}
+	return retryAfter(attempts.LockedUntil.Time), nil
+}
+
+// Fail records a failed attempt and returns the lockout it triggered, zero
+// while key still has free attempts left.
+func (l *Limiter) Fail(ctx context.Context, key string) (time.Duration, error) {
+	attempts, err := l.db.RecordFailedAttempt(ctx, database.RecordFailedAttemptParams{
+		Key:         l.prefix + key,
+		WindowStart: time.Now().UTC().Add(-l.policy.Window),
+	})
+	if err != nil {
+		return 0, err
+	}
+	lockout := l.lockout(int(attempts.Failures))
+	if lockout == 0 {
+		return 0, nil
+	}
+	lockedUntil := time.Now().UTC().Add(lockout)
+	err = l.db.LockFailedAttempts(ctx, database.LockFailedAttemptsParams{
+		LockedUntil: sql.NullTime{Time: lockedUntil, Valid: true},
+		Key:         l.prefix + key,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return lockout, nil
+}
+
+// Reset forgets every failure recorded for key.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.db.ClearFailedAttempts(ctx, l.prefix+key)
+}
+
+// Run deletes counters that fell out of the window every interval until ctx
+// is done.
+func (l *Limiter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := l.db.DeleteStaleFailedAttempts(ctx, database.DeleteStaleFailedAttemptsParams{
+				KeyPattern:  l.prefix + "%",
+				WindowStart: time.Now().UTC().Add(-l.policy.Window),
+			})
+			if err != nil {
+				log.Printf("Error pruning %s failed attempts: %s", l.prefix, err)
+			}
+		}
+	}
+}
+
+func (l *Limiter) lockout(failures int) time.Duration {
+	over := failures - l.policy.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	lockout := l.policy.BaseLockout
+	for i := 1; i < over && lockout < l.policy.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > l.policy.MaxLockout {
+		lockout = l.policy.MaxLockout
+	}
+	return lockout
+}
+
+func retryAfter(lockedUntil time.Time) time.Duration {
+	d := time.Until(lockedUntil)
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,140 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxCBORDepth stops maliciously nested input from exhausting the stack.
+const maxCBORDepth = 16
+
+var errCBORTruncated = errors.New("webauthn: truncated CBOR")
+
+// decodeCBOR decodes the first CBOR item in data and returns it along with
+// the bytes after it. Only what authenticators send is supported: definite
+// lengths, integers, byte and text strings, arrays, maps, booleans and
+// null. Integers come back as int64, maps as map[any]any.
+func decodeCBOR(data []byte) (any, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (any, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, errors.New("webauthn: CBOR nested too deeply")
+	}
+	if len(data) == 0 {
+		return nil, nil, errCBORTruncated
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22:
+			return nil, data, nil
+		default:
+			return nil, nil, fmt.Errorf("webauthn: unsupported CBOR simple value %d", info)
+		}
+	}
+
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 24:
+		if len(data) < 1 {
+			return nil, nil, errCBORTruncated
+		}
+		arg, data = uint64(data[0]), data[1:]
+	case info == 25:
+		if len(data) < 2 {
+			return nil, nil, errCBORTruncated
+		}
+		arg, data = uint64(binary.BigEndian.Uint16(data)), data[2:]
+	case info == 26:
+		if len(data) < 4 {
+			return nil, nil, errCBORTruncated
+		}
+		arg, data = uint64(binary.BigEndian.Uint32(data)), data[4:]
+	case info == 27:
+		if len(data) < 8 {
+			return nil, nil, errCBORTruncated
+		}
+		arg, data = binary.BigEndian.Uint64(data), data[8:]
+	default:
+		return nil, nil, errors.New("webauthn: indefinite-length CBOR is not supported")
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("webauthn: CBOR integer overflows int64")
+		}
+		return int64(arg), data, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("webauthn: CBOR integer overflows int64")
+		}
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBORTruncated
+		}
+		value := data[:arg]
+		if major == 3 {
+			return string(value), data[arg:], nil
+		}
+		return append([]byte{}, value...), data[arg:], nil
+	case 4:
+		// every item takes at least a byte, which bounds the allocation
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBORTruncated
+		}
+		items := make([]any, 0, arg)
+		for range arg {
+			var item any
+			var err error
+			item, data, err = decodeCBORItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBORTruncated
+		}
+		items := make(map[any]any, arg)
+		for range arg {
+			var key, value any
+			var err error
+			key, data, err = decodeCBORItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.New("webauthn: unsupported CBOR map key")
+			}
+			value, data, err = decodeCBORItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, ok := items[key]; ok {
+				return nil, nil, errors.New("webauthn: duplicate CBOR map key")
+			}
+			items[key] = value
+		}
+		return items, data, nil
+	default:
+		return nil, nil, fmt.Errorf("webauthn: unsupported CBOR major type %d", major)
+	}
+}
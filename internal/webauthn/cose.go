@@ -0,0 +1,128 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms (RFC 9053) we accept credentials for, in order of
+// preference.
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+var Algorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters (RFC 9052 section 7 and RFC 9053 section 7).
+const (
+	coseKty = 1
+	coseAlg = 3
+	// the same labels mean crv/x/y for EC2 and OKP keys and n/e for RSA
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1
+	coseE   = -2
+
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+)
+
+// PublicKey is a credential public key parsed from its COSE encoding.
+type PublicKey struct {
+	Algorithm int64
+	key       crypto.PublicKey
+}
+
+// ParsePublicKey parses a COSE_Key as found in attested credential data.
+func ParsePublicKey(coseKey []byte) (*PublicKey, error) {
+	item, rest, err := decodeCBOR(coseKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("webauthn: trailing bytes after COSE key")
+	}
+	params, ok := item.(map[any]any)
+	if !ok {
+		return nil, errors.New("webauthn: COSE key is not a map")
+	}
+	kty, _ := params[int64(coseKty)].(int64)
+	alg, _ := params[int64(coseAlg)].(int64)
+	bytesParam := func(label int64) []byte {
+		b, _ := params[label].([]byte)
+		return b
+	}
+
+	switch {
+	case alg == AlgES256 && kty == coseKtyEC2:
+		if crv, _ := params[int64(coseCrv)].(int64); crv != coseCrvP256 {
+			return nil, fmt.Errorf("webauthn: unsupported curve %d for ES256", crv)
+		}
+		x, y := bytesParam(coseX), bytesParam(coseY)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("webauthn: bad P-256 coordinates")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("webauthn: point is not on the curve")
+		}
+		return &PublicKey{Algorithm: alg, key: key}, nil
+	case alg == AlgEdDSA && kty == coseKtyOKP:
+		if crv, _ := params[int64(coseCrv)].(int64); crv != coseCrvEd25519 {
+			return nil, fmt.Errorf("webauthn: unsupported curve %d for EdDSA", crv)
+		}
+		x := bytesParam(coseX)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("webauthn: bad Ed25519 key size")
+		}
+		return &PublicKey{Algorithm: alg, key: ed25519.PublicKey(x)}, nil
+	case alg == AlgRS256 && kty == coseKtyRSA:
+		n, e := bytesParam(coseN), bytesParam(coseE)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("webauthn: bad RSA key")
+		}
+		return &PublicKey{Algorithm: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+	default:
+		return nil, fmt.Errorf("webauthn: unsupported key type %d with algorithm %d", kty, alg)
+	}
+}
+
+// Verify checks sig over data. ES256 signatures are ASN.1 encoded, as
+// WebAuthn specifies.
+func (k *PublicKey) Verify(data, sig []byte) error {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(key, digest[:], sig) {
+			return errors.New("webauthn: invalid signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, sig) {
+			return errors.New("webauthn: invalid signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+			return errors.New("webauthn: invalid signature")
+		}
+		return nil
+	default:
+		return errors.New("webauthn: unsupported key")
+	}
+}
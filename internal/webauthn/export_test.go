@@ -0,0 +1,4 @@
+package webauthn
+
+// DecodeCBOR lets the external tests feed the CBOR parser directly.
+var DecodeCBOR = decodeCBOR
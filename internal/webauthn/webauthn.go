@@ -0,0 +1,403 @@
+// Package webauthn is the relying party side of WebAuthn passkeys:
+// creation and request options, and verification of what the browser
+// sends back from navigator.credentials.create() and .get() in the JSON
+// form of WebAuthn Level 3. Only what Chirpy needs is implemented:
+// attestation isn't asked for, so it isn't verified either, and keys are
+// ES256, EdDSA or RS256.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Timeout is how long the browser is given to finish a ceremony, and so
+// how long a challenge needs to be kept.
+const Timeout = 5 * time.Minute
+
+const maxCredentialIDLength = 1023
+
+// Authenticator data flags.
+const (
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+	flagExtensionData    = 0x80
+)
+
+var (
+	ErrChallenge = errors.New("webauthn: challenge doesn't match")
+	// ErrSignCount means the authenticator's counter went backwards, which
+	// happens when a credential was cloned.
+	ErrSignCount = errors.New("webauthn: signature counter went backwards")
+)
+
+// Base64URL is binary data sent as unpadded base64url in JSON, as
+// WebAuthn does.
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return fmt.Errorf("webauthn: invalid base64url: %w", err)
+	}
+	*b = decoded
+	return nil
+}
+
+// NewChallenge returns a random challenge for one ceremony.
+func NewChallenge() (Base64URL, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// RelyingParty is the site passkeys are created for. ID is its domain and
+// Origin what browsers report as the page's origin, e.g. "chirpy.example"
+// and "https://chirpy.example".
+type RelyingParty struct {
+	ID     string
+	Name   string
+	Origin string
+}
+
+type RPEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+type CredentialParameters struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string    `json:"type"`
+	ID         Base64URL `json:"id"`
+	Transports []string  `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are the publicKey options of
+// navigator.credentials.create().
+type CreationOptions struct {
+	RP                     RPEntity               `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              Base64URL              `json:"challenge"`
+	PubKeyCredParams       []CredentialParameters `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the publicKey options of navigator.credentials.get().
+type RequestOptions struct {
+	Challenge        Base64URL              `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions asks for a discoverable credential, so the user can
+// later log in without typing their email. exclude lists the user's
+// existing credentials, so the same authenticator isn't registered twice.
+func (rp *RelyingParty) CreationOptions(challenge Base64URL, user UserEntity, exclude []CredentialDescriptor) CreationOptions {
+	params := []CredentialParameters{}
+	for _, alg := range Algorithms {
+		params = append(params, CredentialParameters{Type: "public-key", Alg: alg})
+	}
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+	return CreationOptions{
+		RP:                 RPEntity{ID: rp.ID, Name: rp.Name},
+		User:               user,
+		Challenge:          challenge,
+		PubKeyCredParams:   params,
+		Timeout:            Timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "preferred",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions lets the user pick any of their passkeys for this site.
+func (rp *RelyingParty) RequestOptions(challenge Base64URL) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          Timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: "preferred",
+	}
+}
+
+// ClientData is the part of clientDataJSON we check.
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// ParseClientData reads clientDataJSON, e.g. to find the challenge an
+// assertion answers before verifying it.
+func ParseClientData(clientDataJSON []byte) (*ClientData, error) {
+	clientData := ClientData{}
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return nil, fmt.Errorf("webauthn: invalid clientDataJSON: %w", err)
+	}
+	return &clientData, nil
+}
+
+func (rp *RelyingParty) checkClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	clientData, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return err
+	}
+	if clientData.Type != ceremony {
+		return fmt.Errorf("webauthn: clientData type is %q, want %q", clientData.Type, ceremony)
+	}
+	expected := base64.RawURLEncoding.EncodeToString(challenge)
+	if subtle.ConstantTimeCompare([]byte(clientData.Challenge), []byte(expected)) != 1 {
+		return ErrChallenge
+	}
+	if clientData.Origin != rp.Origin || clientData.CrossOrigin {
+		return fmt.Errorf("webauthn: unexpected origin %q", clientData.Origin)
+	}
+	return nil
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("webauthn: authenticator data too short")
+	}
+	authData := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+	if authData.flags&flagAttestedCredData != 0 {
+		// AAGUID, then the credential ID and its length
+		if len(rest) < 18 {
+			return nil, errors.New("webauthn: attested credential data too short")
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength > maxCredentialIDLength || len(rest) < idLength {
+			return nil, errors.New("webauthn: bad credential ID length")
+		}
+		authData.credentialID = rest[:idLength]
+		rest = rest[idLength:]
+		// the key is the first CBOR item, extensions may follow it
+		_, afterKey, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("webauthn: credential public key: %w", err)
+		}
+		authData.publicKey = rest[:len(rest)-len(afterKey)]
+		rest = afterKey
+	}
+	if authData.flags&flagExtensionData != 0 {
+		_, afterExtensions, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("webauthn: extensions: %w", err)
+		}
+		rest = afterExtensions
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("webauthn: trailing bytes in authenticator data")
+	}
+	return authData, nil
+}
+
+func (rp *RelyingParty) checkAuthenticatorData(authData *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(authData.rpIDHash, rpIDHash[:]) {
+		return errors.New("webauthn: credential is for another site")
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return errors.New("webauthn: user was not present")
+	}
+	return nil
+}
+
+// RegistrationResponse is navigator.credentials.create()'s result as
+// PublicKeyCredential.toJSON() serializes it.
+type RegistrationResponse struct {
+	ID       string    `json:"id"`
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AttestationObject Base64URL `json:"attestationObject"`
+		Transports        []string  `json:"transports"`
+	} `json:"response"`
+}
+
+// Credential is a newly registered passkey, ready to be stored.
+type Credential struct {
+	ID []byte
+	// PublicKey is COSE encoded, for ParsePublicKey.
+	PublicKey    []byte
+	Algorithm    int64
+	SignCount    uint32
+	UserVerified bool
+	Transports   []string
+}
+
+// VerifyRegistration checks a new credential against the challenge of the
+// creation options it answers.
+func (rp *RelyingParty) VerifyRegistration(resp RegistrationResponse, challenge []byte) (*Credential, error) {
+	if resp.Type != "public-key" {
+		return nil, fmt.Errorf("webauthn: unexpected credential type %q", resp.Type)
+	}
+	if err := rp.checkClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+	item, rest, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: attestation object: %w", err)
+	}
+	attestation, ok := item.(map[any]any)
+	if !ok || len(rest) != 0 {
+		return nil, errors.New("webauthn: attestation object is not a map")
+	}
+	// We ask for no attestation, but some authenticators send theirs
+	// anyway. It only says which make of authenticator this is, which we
+	// don't care about, so it is ignored like "none".
+	if _, ok := attestation["fmt"].(string); !ok {
+		return nil, errors.New("webauthn: attestation object has no format")
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("webauthn: attestation object has no authenticator data")
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.checkAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+	if authData.flags&flagAttestedCredData == 0 {
+		return nil, errors.New("webauthn: no credential in authenticator data")
+	}
+	if !bytes.Equal(authData.credentialID, resp.RawID) {
+		return nil, errors.New("webauthn: credential ID doesn't match")
+	}
+	publicKey, err := ParsePublicKey(authData.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(Algorithms, publicKey.Algorithm) {
+		return nil, fmt.Errorf("webauthn: algorithm %d was not offered", publicKey.Algorithm)
+	}
+	return &Credential{
+		ID:           authData.credentialID,
+		PublicKey:    authData.publicKey,
+		Algorithm:    publicKey.Algorithm,
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&flagUserVerified != 0,
+		Transports:   resp.Response.Transports,
+	}, nil
+}
+
+// AssertionResponse is navigator.credentials.get()'s result as
+// PublicKeyCredential.toJSON() serializes it.
+type AssertionResponse struct {
+	ID       string    `json:"id"`
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AuthenticatorData Base64URL `json:"authenticatorData"`
+		Signature         Base64URL `json:"signature"`
+		UserHandle        Base64URL `json:"userHandle"`
+	} `json:"response"`
+}
+
+// Assertion is what a verified login tells us.
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+// VerifyAssertion checks a login with the stored credential resp.RawID
+// names: publicKey as returned by VerifyRegistration and the signature
+// counter as last seen.
+func (rp *RelyingParty) VerifyAssertion(resp AssertionResponse, challenge, publicKey []byte, signCount uint32) (*Assertion, error) {
+	if resp.Type != "public-key" {
+		return nil, fmt.Errorf("webauthn: unexpected credential type %q", resp.Type)
+	}
+	if err := rp.checkClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+	authData, err := parseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.checkAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte{}, resp.Response.AuthenticatorData...), clientDataHash[:]...)
+	if err := key.Verify(signed, resp.Response.Signature); err != nil {
+		return nil, err
+	}
+	// authenticators without a counter always send zero
+	if (authData.signCount != 0 || signCount != 0) && authData.signCount <= signCount {
+		return nil, ErrSignCount
+	}
+	return &Assertion{
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&flagUserVerified != 0,
+	}, nil
+}
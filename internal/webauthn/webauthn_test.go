@@ -0,0 +1,463 @@
+package webauthn_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/Ayannamdeo/chirpy/internal/webauthn"
+	"github.com/Ayannamdeo/chirpy/internal/webauthn/webauthntest"
+)
+
+const testOrigin = "http://localhost:8080"
+
+var (
+	testRP   = &webauthn.RelyingParty{ID: "localhost", Name: "Chirpy", Origin: testOrigin}
+	testUser = webauthn.UserEntity{ID: []byte("user-1"), Name: "user@example.com", DisplayName: "user@example.com"}
+)
+
+func newChallenge(t *testing.T) webauthn.Base64URL {
+	t.Helper()
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+// viaJSON sends v through JSON and back, as it travels between browser
+// and server.
+func viaJSON[T any](t *testing.T, v T) T {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out T
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func register(t *testing.T, a *webauthntest.Authenticator) *webauthn.Credential {
+	t.Helper()
+	challenge := newChallenge(t)
+	resp, err := a.Create(viaJSON(t, testRP.CreationOptions(challenge, testUser, nil)))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	cred, err := testRP.VerifyRegistration(viaJSON(t, resp), challenge)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	return cred
+}
+
+func assert(t *testing.T, a *webauthntest.Authenticator) (webauthn.AssertionResponse, webauthn.Base64URL) {
+	t.Helper()
+	challenge := newChallenge(t)
+	resp, err := a.Get(viaJSON(t, testRP.RequestOptions(challenge)))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	return viaJSON(t, resp), challenge
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, alg := range []int64{webauthn.AlgES256, webauthn.AlgEdDSA} {
+		a := webauthntest.New(testOrigin)
+		a.Algorithm = alg
+		cred := register(t, a)
+		if cred.Algorithm != alg {
+			t.Errorf("algorithm = %d, want %d", cred.Algorithm, alg)
+		}
+		if !cred.UserVerified {
+			t.Errorf("alg %d: user verification was lost", alg)
+		}
+
+		signCount := cred.SignCount
+		for range 2 {
+			resp, challenge := assert(t, a)
+			if string(resp.Response.UserHandle) != string(testUser.ID) {
+				t.Errorf("alg %d: user handle = %q, want %q", alg, resp.Response.UserHandle, testUser.ID)
+			}
+			assertion, err := testRP.VerifyAssertion(resp, challenge, cred.PublicKey, signCount)
+			if err != nil {
+				t.Fatalf("alg %d: VerifyAssertion: %v", alg, err)
+			}
+			if assertion.SignCount <= signCount || !assertion.UserVerified {
+				t.Errorf("alg %d: unexpected assertion %+v", alg, assertion)
+			}
+			signCount = assertion.SignCount
+		}
+	}
+}
+
+func TestUserNotVerified(t *testing.T) {
+	a := webauthntest.New(testOrigin)
+	a.UserVerification = false
+	cred := register(t, a)
+	resp, challenge := assert(t, a)
+	assertion, err := testRP.VerifyAssertion(resp, challenge, cred.PublicKey, cred.SignCount)
+	if err != nil {
+		t.Fatalf("VerifyAssertion: %v", err)
+	}
+	if assertion.UserVerified {
+		t.Error("assertion says the user was verified")
+	}
+}
+
+func TestWrongOrigin(t *testing.T) {
+	// same host, so the authenticator agrees to the RP ID
+	a := webauthntest.New("http://localhost:9999")
+	challenge := newChallenge(t)
+	resp, err := a.Create(testRP.CreationOptions(challenge, testUser, nil))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := testRP.VerifyRegistration(resp, challenge); err == nil {
+		t.Error("VerifyRegistration accepted another origin")
+	}
+
+	a = webauthntest.New(testOrigin)
+	cred := register(t, a)
+	a.Origin = "http://localhost:9999"
+	challenge = newChallenge(t)
+	assertion, err := a.Get(testRP.RequestOptions(challenge))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if _, err := testRP.VerifyAssertion(assertion, challenge, cred.PublicKey, 0); err == nil {
+		t.Error("VerifyAssertion accepted another origin")
+	}
+}
+
+func TestWrongRPIDHash(t *testing.T) {
+	other := &webauthn.RelyingParty{ID: "other.localhost", Name: "Other", Origin: testOrigin}
+	a := webauthntest.New(testOrigin)
+	challenge := newChallenge(t)
+	resp, err := a.Create(testRP.CreationOptions(challenge, testUser, nil))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := other.VerifyRegistration(resp, challenge); err == nil {
+		t.Error("VerifyRegistration accepted a credential for another RP ID")
+	}
+	cred, err := testRP.VerifyRegistration(resp, challenge)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	assertion, challenge := assert(t, a)
+	if _, err := other.VerifyAssertion(assertion, challenge, cred.PublicKey, cred.SignCount); err == nil {
+		t.Error("VerifyAssertion accepted an assertion for another RP ID")
+	}
+}
+
+func TestReplayedChallenge(t *testing.T) {
+	a := webauthntest.New(testOrigin)
+	cred := register(t, a)
+	resp, challenge := assert(t, a)
+	assertion, err := testRP.VerifyAssertion(resp, challenge, cred.PublicKey, cred.SignCount)
+	if err != nil {
+		t.Fatalf("VerifyAssertion: %v", err)
+	}
+	// an old answer to the next login's challenge
+	if _, err := testRP.VerifyAssertion(resp, newChallenge(t), cred.PublicKey, assertion.SignCount); !errors.Is(err, webauthn.ErrChallenge) {
+		t.Errorf("got %v, want ErrChallenge", err)
+	}
+	// the same answer again, should the server forget the challenge was used
+	if _, err := testRP.VerifyAssertion(resp, challenge, cred.PublicKey, assertion.SignCount); !errors.Is(err, webauthn.ErrSignCount) {
+		t.Errorf("got %v, want ErrSignCount", err)
+	}
+
+	regChallenge := newChallenge(t)
+	reg, err := webauthntest.New(testOrigin).Create(testRP.CreationOptions(regChallenge, testUser, nil))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := testRP.VerifyRegistration(reg, newChallenge(t)); !errors.Is(err, webauthn.ErrChallenge) {
+		t.Errorf("got %v, want ErrChallenge", err)
+	}
+}
+
+func TestSignCountBackwards(t *testing.T) {
+	a := webauthntest.New(testOrigin)
+	cred := register(t, a)
+	resp, challenge := assert(t, a)
+	// the stored counter is ahead of the authenticator's: a clone was used
+	if _, err := testRP.VerifyAssertion(resp, challenge, cred.PublicKey, 5); !errors.Is(err, webauthn.ErrSignCount) {
+		t.Errorf("got %v, want ErrSignCount", err)
+	}
+}
+
+func TestTamperedAssertion(t *testing.T) {
+	a := webauthntest.New(testOrigin)
+	cred := register(t, a)
+	resp, challenge := assert(t, a)
+
+	tampered := viaJSON(t, resp)
+	tampered.Response.Signature[len(tampered.Response.Signature)-1] ^= 1
+	if _, err := testRP.VerifyAssertion(tampered, challenge, cred.PublicKey, cred.SignCount); err == nil {
+		t.Error("VerifyAssertion accepted a tampered signature")
+	}
+	tampered = viaJSON(t, resp)
+	tampered.Response.AuthenticatorData[32] &^= 0x01 // user present
+	if _, err := testRP.VerifyAssertion(tampered, challenge, cred.PublicKey, cred.SignCount); err == nil {
+		t.Error("VerifyAssertion accepted an assertion without user presence")
+	}
+	tampered = viaJSON(t, resp)
+	tampered.Response.AuthenticatorData = tampered.Response.AuthenticatorData[:36]
+	if _, err := testRP.VerifyAssertion(tampered, challenge, cred.PublicKey, cred.SignCount); err == nil {
+		t.Error("VerifyAssertion accepted truncated authenticator data")
+	}
+
+	other := register(t, webauthntest.New(testOrigin))
+	if _, err := testRP.VerifyAssertion(resp, challenge, other.PublicKey, cred.SignCount); err == nil {
+		t.Error("VerifyAssertion accepted another credential's signature")
+	}
+}
+
+func TestCeremonyType(t *testing.T) {
+	a := webauthntest.New(testOrigin)
+	challenge := newChallenge(t)
+	reg, err := a.Create(testRP.CreationOptions(challenge, testUser, nil))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	cred, err := testRP.VerifyRegistration(reg, challenge)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	resp, challenge := assert(t, a)
+	// clientDataJSON of a registration in place of a login's
+	resp.Response.ClientDataJSON = reg.Response.ClientDataJSON
+	if _, err := testRP.VerifyAssertion(resp, challenge, cred.PublicKey, cred.SignCount); err == nil {
+		t.Error("VerifyAssertion accepted webauthn.create client data")
+	}
+}
+
+func TestBase64URL(t *testing.T) {
+	var b webauthn.Base64URL
+	for _, in := range []string{`"AQID"`, `"AQI"`, `"AQI="`} {
+		if err := json.Unmarshal([]byte(in), &b); err != nil {
+			t.Errorf("%s: %v", in, err)
+		}
+	}
+	if err := json.Unmarshal([]byte(`"AQ+/"`), &b); err == nil {
+		t.Error("standard base64 was accepted")
+	}
+	out, err := json.Marshal(webauthn.Base64URL{0xfb, 0xff})
+	if err != nil || string(out) != `"-_8"` {
+		t.Errorf("got %s, %v", out, err)
+	}
+}
+
+// cborMap is a CBOR map for encodeCBOR, keys and values alternating.
+type cborMap []any
+
+// encodeCBOR is just enough CBOR to build COSE keys for the tests.
+func encodeCBOR(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		if n < 24 {
+			return []byte{major<<5 | byte(n)}
+		}
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+	}
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case int64:
+		return encodeCBOR(int(v))
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case []any:
+		out := head(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, encodeCBOR(item)...)
+		}
+		return out
+	case cborMap:
+		out := head(5, uint64(len(v)/2))
+		for _, item := range v {
+			out = append(out, encodeCBOR(item)...)
+		}
+		return out
+	}
+	panic(fmt.Sprintf("can't encode %T", v))
+}
+
+func es256Key(t *testing.T) (*ecdsa.PrivateKey, cborMap) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	return key, cborMap{1, 2, 3, webauthn.AlgES256, -1, 1, -2, x, -3, y}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	item, rest, err := webauthn.DecodeCBOR([]byte("\xa3\x01\x02\x20\x43abc\x61k\xf5\xff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[any]any{int64(1): int64(2), int64(-1): []byte("abc"), "k": true}
+	if !reflect.DeepEqual(item, want) || !bytes.Equal(rest, []byte{0xff}) {
+		t.Errorf("got %#v, rest %x", item, rest)
+	}
+
+	deep := append(bytes.Repeat([]byte{0x81}, 20), 0x00)
+	for name, input := range map[string]string{
+		"empty":                 "",
+		"truncated uint8":       "\x18",
+		"truncated uint16":      "\x19\x01",
+		"truncated uint32":      "\x1a\x01\x02",
+		"truncated uint64":      "\x1b\x01\x02\x03",
+		"truncated bytes":       "\x43ab",
+		"truncated text":        "\x63ab",
+		"truncated array":       "\x82\x01",
+		"truncated map":         "\xa1\x01",
+		"huge bytes":            "\x5b\xff\xff\xff\xff\xff\xff\xff\xff",
+		"huge array":            "\x9b\xff\xff\xff\xff\xff\xff\xff\xff",
+		"huge map":              "\xbb\xff\xff\xff\xff\xff\xff\xff\xff",
+		"int64 overflow":        "\x1b\xff\xff\xff\xff\xff\xff\xff\xff",
+		"negative overflow":     "\x3b\x80\x00\x00\x00\x00\x00\x00\x00",
+		"indefinite bytes":      "\x5f\x41a\xff",
+		"indefinite array":      "\x9f\x01\xff",
+		"reserved length":       "\x1c",
+		"tag":                   "\xc0\x00",
+		"float":                 "\xfa\x00\x00\x00\x00",
+		"undefined":             "\xf7",
+		"duplicate key":         "\xa2\x01\x00\x01\x00",
+		"byte string key":       "\xa1\x41k\x00",
+		"nested too deeply":     string(deep),
+		"map value missing":     "\xa1\x01",
+		"truncated inside list": "\x81\x43a",
+	} {
+		if _, _, err := webauthn.DecodeCBOR([]byte(input)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestDecodeCBORTruncated(t *testing.T) {
+	a := webauthntest.New(testOrigin)
+	resp, err := a.Create(testRP.CreationOptions(newChallenge(t), testUser, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := resp.Response.AttestationObject
+	for i := range len(object) {
+		if _, _, err := webauthn.DecodeCBOR(object[:i]); err == nil {
+			t.Errorf("attestation object cut at %d of %d bytes decoded", i, len(object))
+		}
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	ecKey, es256 := es256Key(t)
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := map[int64][]byte{
+		webauthn.AlgES256: encodeCBOR(es256),
+		webauthn.AlgEdDSA: encodeCBOR(cborMap{1, 1, 3, webauthn.AlgEdDSA, -1, 6, -2, []byte(edKey)}),
+		webauthn.AlgRS256: encodeCBOR(cborMap{1, 3, 3, webauthn.AlgRS256, -1, rsaKey.N.Bytes(), -2, big.NewInt(int64(rsaKey.E)).Bytes()}),
+	}
+	for alg, coseKey := range valid {
+		key, err := webauthn.ParsePublicKey(coseKey)
+		if err != nil {
+			t.Errorf("alg %d: %v", alg, err)
+			continue
+		}
+		if key.Algorithm != alg {
+			t.Errorf("algorithm = %d, want %d", key.Algorithm, alg)
+		}
+		for i := range len(coseKey) {
+			if _, err := webauthn.ParsePublicKey(coseKey[:i]); err == nil {
+				t.Errorf("alg %d: key cut at %d of %d bytes parsed", alg, i, len(coseKey))
+			}
+		}
+		if _, err := webauthn.ParsePublicKey(append(coseKey, 0x00)); err == nil {
+			t.Errorf("alg %d: trailing byte accepted", alg)
+		}
+	}
+
+	x := es256[7].([]byte)
+	offCurve := slices.Clone(es256)
+	offCurve[9] = bytes.Repeat([]byte{0x01}, 32)
+	for name, coseKey := range map[string]any{
+		"not a map":          []any{1, 2},
+		"no algorithm":       cborMap{1, 2, -1, 1, -2, x, -3, x},
+		"unknown algorithm":  cborMap{1, 2, 3, -35, -1, 2, -2, x, -3, x},
+		"key type mismatch":  cborMap{1, 1, 3, webauthn.AlgES256, -1, 1, -2, x, -3, x},
+		"wrong curve":        cborMap{1, 2, 3, webauthn.AlgES256, -1, 2, -2, x, -3, x},
+		"short coordinate":   cborMap{1, 2, 3, webauthn.AlgES256, -1, 1, -2, x[:31], -3, x},
+		"point not on curve": offCurve,
+		"short Ed25519 key":  cborMap{1, 1, 3, webauthn.AlgEdDSA, -1, 6, -2, x[:31]},
+		"Ed448":              cborMap{1, 1, 3, webauthn.AlgEdDSA, -1, 7, -2, x},
+		"short RSA modulus":  cborMap{1, 3, 3, webauthn.AlgRS256, -1, x, -2, []byte{1, 0, 1}},
+		"no RSA exponent":    cborMap{1, 3, 3, webauthn.AlgRS256, -1, rsaKey.N.Bytes()},
+		"text coordinate":    cborMap{1, 2, 3, webauthn.AlgES256, -1, 1, -2, string(x), -3, x},
+	} {
+		if _, err := webauthn.ParsePublicKey(encodeCBOR(coseKey)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	// the parsed key verifies what the private key signed, and only that
+	key, err := webauthn.ParsePublicKey(valid[webauthn.AlgES256])
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("data"))
+	sig, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := key.Verify([]byte("data"), sig); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := key.Verify([]byte("other data"), sig); err == nil {
+		t.Error("Verify accepted a signature over other data")
+	}
+	if err := key.Verify([]byte("data"), []byte("not a signature")); err == nil {
+		t.Error("Verify accepted garbage")
+	}
+}
+
+func FuzzDecodeCBOR(f *testing.F) {
+	f.Add([]byte("\xa3\x01\x02\x20\x43abc\x61k\xf5"))
+	f.Add([]byte("\x9b\xff\xff\xff\xff\xff\xff\xff\xff"))
+	f.Add(append(bytes.Repeat([]byte{0x81}, 20), 0x00))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// only must not panic
+		webauthn.DecodeCBOR(data)
+		webauthn.ParsePublicKey(data)
+	})
+}
@@ -0,0 +1,309 @@
+// Package webauthntest is a software authenticator for trying out and
+// testing passkeys without a browser or a security key. It creates ES256
+// or EdDSA discoverable credentials and answers
+// navigator.credentials.create() and .get() options the way a browser
+// would, with the user always present.
+package webauthntest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+
+	"github.com/Ayannamdeo/chirpy/internal/webauthn"
+)
+
+type credential struct {
+	id         []byte
+	key        crypto.Signer
+	rpID       string
+	userHandle []byte
+	signCount  uint32
+}
+
+// Authenticator holds credentials for the sites it is used on, as if it
+// were a browser open at Origin.
+type Authenticator struct {
+	Origin string
+	// UserVerification makes it report that the user was verified, as if
+	// it had asked for a PIN or a fingerprint.
+	UserVerification bool
+	// Algorithm is the COSE algorithm of new credentials, AlgES256 or
+	// AlgEdDSA.
+	Algorithm int64
+
+	mu          sync.Mutex
+	credentials []*credential
+}
+
+// New makes an authenticator with no credentials that verifies the user.
+// origin is the page it is used from, e.g. "http://localhost:8080".
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin, UserVerification: true, Algorithm: webauthn.AlgES256}
+}
+
+func (a *Authenticator) rpID() (string, error) {
+	origin, err := url.Parse(a.Origin)
+	if err != nil {
+		return "", err
+	}
+	return origin.Hostname(), nil
+}
+
+// checkRPID is what browsers do: a site can only use its own domain or a
+// parent of it as the relying party ID.
+func (a *Authenticator) checkRPID(rpID string) error {
+	host, err := a.rpID()
+	if err != nil {
+		return err
+	}
+	if rpID != "" && rpID != host && !(len(host) > len(rpID) && host[len(host)-len(rpID)-1:] == "."+rpID) {
+		return fmt.Errorf("webauthntest: %q can't use relying party %q", a.Origin, rpID)
+	}
+	return nil
+}
+
+// Create is navigator.credentials.create(): it makes a new credential
+// and returns it the way the browser sends it to the server.
+func (a *Authenticator) Create(options webauthn.CreationOptions) (webauthn.RegistrationResponse, error) {
+	if err := a.checkRPID(options.RP.ID); err != nil {
+		return webauthn.RegistrationResponse{}, err
+	}
+	if !slices.ContainsFunc(options.PubKeyCredParams, func(p webauthn.CredentialParameters) bool {
+		return p.Type == "public-key" && p.Alg == a.Algorithm
+	}) {
+		return webauthn.RegistrationResponse{}, fmt.Errorf("webauthntest: algorithm %d was not offered", a.Algorithm)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, excluded := range options.ExcludeCredentials {
+		if a.find(options.RP.ID, excluded.ID) != nil {
+			return webauthn.RegistrationResponse{}, errors.New("webauthntest: a credential for this user already exists")
+		}
+	}
+	var key crypto.Signer
+	var err error
+	switch a.Algorithm {
+	case webauthn.AlgES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case webauthn.AlgEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("webauthntest: unsupported algorithm %d", a.Algorithm)
+	}
+	if err != nil {
+		return webauthn.RegistrationResponse{}, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return webauthn.RegistrationResponse{}, err
+	}
+	cred := &credential{
+		id:         id,
+		key:        key,
+		rpID:       options.RP.ID,
+		userHandle: options.User.ID,
+	}
+	// a new credential replaces the user's old one, like resident keys do
+	a.credentials = slices.DeleteFunc(a.credentials, func(c *credential) bool {
+		return c.rpID == cred.rpID && string(c.userHandle) == string(cred.userHandle)
+	})
+	a.credentials = append(a.credentials, cred)
+
+	clientDataJSON, err := a.clientData("webauthn.create", options.Challenge)
+	if err != nil {
+		return webauthn.RegistrationResponse{}, err
+	}
+	authData := a.authenticatorData(cred, 0x40)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(id)))
+	authData = append(authData, id...)
+	authData = appendCOSEKey(authData, key.Public())
+
+	var attestationObject []byte
+	attestationObject = appendHead(attestationObject, 5, 3)
+	attestationObject = appendText(attestationObject, "fmt")
+	attestationObject = appendText(attestationObject, "none")
+	attestationObject = appendText(attestationObject, "attStmt")
+	attestationObject = appendHead(attestationObject, 5, 0)
+	attestationObject = appendText(attestationObject, "authData")
+	attestationObject = appendBytes(attestationObject, authData)
+
+	resp := webauthn.RegistrationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(id),
+		RawID: id,
+		Type:  "public-key",
+	}
+	resp.Response.ClientDataJSON = clientDataJSON
+	resp.Response.AttestationObject = attestationObject
+	resp.Response.Transports = []string{"internal"}
+	return resp, nil
+}
+
+// Get is navigator.credentials.get(): it signs the challenge with a
+// credential for the site, one of options.AllowCredentials if there are
+// any.
+func (a *Authenticator) Get(options webauthn.RequestOptions) (webauthn.AssertionResponse, error) {
+	if err := a.checkRPID(options.RPID); err != nil {
+		return webauthn.AssertionResponse{}, err
+	}
+	rpID := options.RPID
+	if rpID == "" {
+		rpID, _ = a.rpID()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var cred *credential
+	if len(options.AllowCredentials) == 0 {
+		cred = a.find(rpID, nil)
+	}
+	for _, allowed := range options.AllowCredentials {
+		if cred = a.find(rpID, allowed.ID); cred != nil {
+			break
+		}
+	}
+	if cred == nil {
+		return webauthn.AssertionResponse{}, errors.New("webauthntest: no credential for this site")
+	}
+	cred.signCount++
+
+	clientDataJSON, err := a.clientData("webauthn.get", options.Challenge)
+	if err != nil {
+		return webauthn.AssertionResponse{}, err
+	}
+	authData := a.authenticatorData(cred, 0)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signature, err := sign(cred.key, append(append([]byte{}, authData...), clientDataHash[:]...))
+	if err != nil {
+		return webauthn.AssertionResponse{}, err
+	}
+
+	resp := webauthn.AssertionResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(cred.id),
+		RawID: cred.id,
+		Type:  "public-key",
+	}
+	resp.Response.ClientDataJSON = clientDataJSON
+	resp.Response.AuthenticatorData = authData
+	resp.Response.Signature = signature
+	resp.Response.UserHandle = cred.userHandle
+	return resp, nil
+}
+
+// find returns the credential with the given ID, or the newest one for
+// rpID if id is nil.
+func (a *Authenticator) find(rpID string, id []byte) *credential {
+	for i := len(a.credentials) - 1; i >= 0; i-- {
+		c := a.credentials[i]
+		if c.rpID == rpID && (id == nil || string(c.id) == string(id)) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) clientData(ceremony string, challenge []byte) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+}
+
+func (a *Authenticator) authenticatorData(cred *credential, flags byte) []byte {
+	flags |= 0x01 // user present
+	if a.UserVerification {
+		flags |= 0x04
+	}
+	rpIDHash := sha256.Sum256([]byte(cred.rpID))
+	authData := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(authData, cred.signCount)
+}
+
+// sign signs data the way WebAuthn wants it for the key's algorithm: ES256
+// as ASN.1 over the SHA-256 digest, EdDSA over the data itself.
+func sign(key crypto.Signer, data []byte) ([]byte, error) {
+	if ecKey, ok := key.(*ecdsa.PrivateKey); ok {
+		digest := sha256.Sum256(data)
+		return ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	}
+	return key.Sign(rand.Reader, data, crypto.Hash(0))
+}
+
+// appendCOSEKey encodes an EC2 P-256 or OKP Ed25519 key with its
+// parameters in CTAP2 canonical order.
+func appendCOSEKey(b []byte, public crypto.PublicKey) []byte {
+	if edKey, ok := public.(ed25519.PublicKey); ok {
+		b = appendHead(b, 5, 4)
+		b = appendInt(b, 1) // kty
+		b = appendInt(b, 1) // OKP
+		b = appendInt(b, 3) // alg
+		b = appendInt(b, webauthn.AlgEdDSA)
+		b = appendInt(b, -1) // crv
+		b = appendInt(b, 6)  // Ed25519
+		b = appendInt(b, -2) // x
+		return appendBytes(b, edKey)
+	}
+	key := public.(*ecdsa.PublicKey)
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	b = appendHead(b, 5, 5)
+	b = appendInt(b, 1) // kty
+	b = appendInt(b, 2) // EC2
+	b = appendInt(b, 3) // alg
+	b = appendInt(b, webauthn.AlgES256)
+	b = appendInt(b, -1) // crv
+	b = appendInt(b, 1)  // P-256
+	b = appendInt(b, -2) // x
+	b = appendBytes(b, x)
+	b = appendInt(b, -3) // y
+	return appendBytes(b, y)
+}
+
+// appendHead encodes a CBOR major type and its argument.
+func appendHead(b []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= 0xff:
+		return append(b, major|24, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, major|27), n)
+	}
+}
+
+func appendInt(b []byte, n int64) []byte {
+	if n < 0 {
+		return appendHead(b, 1, uint64(-1-n))
+	}
+	return appendHead(b, 0, uint64(n))
+}
+
+func appendBytes(b, data []byte) []byte {
+	return append(appendHead(b, 2, uint64(len(data))), data...)
+}
+
+func appendText(b []byte, s string) []byte {
+	return append(appendHead(b, 3, uint64(len(s))), s...)
+}
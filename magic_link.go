@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/Ayannamdeo/chirpy/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const magicLinkTTL = 15 * time.Minute
+
+// magicLinkHandler mails a link that logs the user in. Like
+// forgotPasswordHandler it answers 202 before looking the email up. Each
+// link is a login credential on its own, so the few an address may be
+// sent in an hour also bound how many are out there at once.
+func (cfg *apiConfig) magicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody := struct {
+		Email string `json:"email"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	emailKey := strings.ToLower(strings.TrimSpace(reqBody.Email))
+	if emailKey == "" {
+		respondWithError(w, http.StatusBadRequest, "email is required", nil)
+		return
+	}
+	wait, err := cfg.magicLinkThrottle.Check(r.Context(), emailKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check sent links", err)
+		return
+	}
+	if wait > 0 {
+		respondWithRetryAfter(w, wait, "too many login links requested, try again later")
+		return
+	}
+	if _, err := cfg.magicLinkThrottle.Fail(r.Context(), emailKey); err != nil {
+		log.Printf("Error counting login link for %s: %v", emailKey, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Minute)
+	ipAddress, userAgent := cfg.clientIP(r), r.UserAgent()
+	go func() {
+		defer cancel()
+		user, ok := cfg.sendMagicLink(ctx, reqBody.Email)
+		if ok {
+			cfg.recordAudit(ctx, auditMagicLinkSent, uuid.Nil, user, ipAddress, userAgent, nil)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendMagicLink mails a login link if email belongs to an account and
+// returns who it went to. Like sendPasswordReset it runs after the
+// request has been answered, so errors are only logged.
+func (cfg *apiConfig) sendMagicLink(ctx context.Context, email string) (uuid.UUID, bool) {
+	user, err := cfg.db.GetUserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error fetching user for login link: %v", err)
+		}
+		return uuid.Nil, false
+	}
+	// the link is bound to the address it was sent to
+	token, err := auth.MakePurposeToken(user.ID, user.Email, auth.PurposeMagicLink, cfg.jwtKeys, magicLinkTTL)
+	if err != nil {
+		log.Printf("Error making login link for user %s: %v", user.ID, err)
+		return uuid.Nil, false
+	}
+	link := cfg.publicURL + "/app/magic-link.html?token=" + url.QueryEscape(token)
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy login link",
+		Body: fmt.Sprintf("Someone asked to log in to your Chirpy account.\n\n"+
+			"Open this link within 15 minutes to log in. It only works once:\n\n%s\n\n"+
+			"If it wasn't you, you can ignore this email.\n", link),
+	})
+	if err != nil {
+		log.Printf("Error sending login link to user %s: %v", user.ID, err)
+	}
+	return user.ID, true
+}
+
+// verifyMagicLinkHandler exchanges a login link for a session, answering
+// like loginHandler.
+func (cfg *apiConfig) verifyMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody := struct {
+		Token       string `json:"token"`
+		DeviceLabel string `json:"device_label"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	claims, err := auth.ParsePurposeToken(reqBody.Token, auth.PurposeMagicLink, cfg.jwtKeys, nil)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid or expired login link", err)
+		return
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid login link", err)
+		return
+	}
+	jti, err := uuid.Parse(claims.ID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid login link", err)
+		return
+	}
+	user, err := cfg.db.GetUserById(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusUnauthorized, "invalid login link", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.Email != claims.Email {
+		respondWithError(w, http.StatusUnauthorized, "login link is no longer valid", nil)
+		return
+	}
+
+	if err := cfg.db.DeleteExpiredMagicLinkUses(r.Context()); err != nil {
+		log.Printf("Error cleaning up used login links: %v", err)
+	}
+	// the use is kept for as long as the link would still be accepted
+	used, err := cfg.db.UseMagicLink(r.Context(), database.UseMagicLinkParams{
+		Jti:       jti,
+		ExpiresAt: claims.ExpiresAt.Time.UTC().Add(auth.JWTLeeway()),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't use login link", err)
+		return
+	}
+	if used == 0 {
+		cfg.audit(r, auditLoginFailed, uuid.Nil, user.ID, auditMeta{"email": user.Email, "reason": "login link reused"})
+		respondWithError(w, http.StatusUnauthorized, "login link was already used", nil)
+		return
+	}
+
+	// opening the link proves the user owns the address
+	if !user.EmailVerifiedAt.Valid {
+		if _, err := cfg.db.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+			ID:    user.ID,
+			Email: user.Email,
+		}); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+			return
+		}
+		if user, err = cfg.db.GetUserById(r.Context(), user.ID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+	}
+	emailKey := strings.ToLower(strings.TrimSpace(user.Email))
+	if err := cfg.magicLinkThrottle.Reset(r.Context(), emailKey); err != nil {
+		log.Printf("Error resetting sent login links for %s: %v", emailKey, err)
+	}
+
+	twoFactor, err := cfg.twoFactorEnabled(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up 2fa", err)
+		return
+	}
+	if twoFactor {
+		cfg.respondWithTwoFactorChallenge(w, user)
+		return
+	}
+	cfg.respondWithSession(w, r, user, reqBody.DeviceLabel, "magic_link")
+}
@@ -1,22 +1,38 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"syscall"
 	"time"
   "sort"
 
 	"github.com/Ayannamdeo/chirpy/internal/auth"
 	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/Ayannamdeo/chirpy/internal/denylist"
+	"github.com/Ayannamdeo/chirpy/internal/mailer"
+	"github.com/Ayannamdeo/chirpy/internal/middleware"
+	"github.com/Ayannamdeo/chirpy/internal/oidc"
+	"github.com/Ayannamdeo/chirpy/internal/throttle"
+	"github.com/Ayannamdeo/chirpy/internal/webauthn"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 func respondWithError(w http.ResponseWriter, status int, msg string, err error) {
@@ -46,14 +62,59 @@ func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
 	w.Write(data)
 }
 
+func respondWithRetryAfter(w http.ResponseWriter, wait time.Duration, msg string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondWithError(w, http.StatusTooManyRequests, msg, nil)
+}
+
 type apiConfig struct {
-	db             *database.Queries
-	platform       string
-	jwtSecret      string
+	db       *database.Queries
+	platform string
+	jwtKeys  *auth.KeySet
+	// refreshTokenKey keys the hashes refresh tokens are stored as
+	refreshTokenKey       []byte
+	denylist              *denylist.Store
+	emailThrottle         *throttle.Limiter
+	ipThrottle            *throttle.Limiter
+	twoFactorThrottle     *throttle.Limiter
+	magicLinkThrottle     *throttle.Limiter
+	passwordResetThrottle *throttle.Limiter
+	deviceCodeThrottle    *throttle.Limiter
+	trustProxy            bool
+	mailer                mailer.Mailer
+	publicURL             string
+	// deletionGrace is how long a deleted account can still be rescued by
+	// logging in
+	deletionGrace time.Duration
+	sessions      sessionPolicies
+	// oidc is nil unless SSO login is configured
+	oidc *oidc.Provider
+	// webauthn is the relying party passkeys are registered for
+	webauthn       *webauthn.RelyingParty
 	polkakey       string
 	fileserverHits atomic.Int32
 }
 
+// clientIP is the address failed logins are counted against. Behind a
+// reverse proxy (TRUST_PROXY_HEADERS=true) it is the last hop the proxy
+// appended to X-Forwarded-For, anything left of it is client supplied.
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	if cfg.trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cfg.fileserverHits.Add(1)
@@ -113,15 +174,14 @@ func (cfg *apiConfig) chirpsHandler(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
-  token, err := auth.GetBearerToken(r.Header)
-  if err !=  nil {
-    //what the status type/code for missing parameters or headers?
-    respondWithError(w, 400, "Error getting token", err)
+  userUUID := middleware.FromContext(r.Context()).UserID
+  author, err := cfg.db.GetUserById(r.Context(), userUUID)
+  if err != nil {
+    respondWithError(w, http.StatusUnauthorized, "Couldn't find user", err)
     return
   }
-  userUUID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-  if err != nil {
-    respondWithError(w, http.StatusUnauthorized, "Invalid JWT token", err)
+  if !author.EmailVerifiedAt.Valid {
+    respondWithError(w, http.StatusForbidden, "verify your email address before posting", nil)
     return
   }
 	if len(reqbody.Body) > 140 {
@@ -153,6 +213,7 @@ type User struct {
 	RefreshToken string    `json:"refresh_token"`
 	ID           uuid.UUID `json:"id"`
   IsChirpyRed bool `json:"is_chirpy_red"`
+  EmailVerified bool `json:"email_verified"`
 }
 
 func (cfg *apiConfig) usersHandler(w http.ResponseWriter, r *http.Request){
@@ -171,6 +232,10 @@ func (cfg *apiConfig) usersHandler(w http.ResponseWriter, r *http.Request){
     return
   }
   hashedPass, err := auth.HashPassword(reqBody.Password)
+  if err != nil {
+    respondWithError(w, http.StatusInternalServerError, "couldn't hash password", err)
+    return
+  }
   user, err := cfg.db.CreateUser(r.Context(), database.CreateUserParams{
     Email: reqBody.Email,
     HashedPassword: hashedPass,
@@ -179,34 +244,47 @@ func (cfg *apiConfig) usersHandler(w http.ResponseWriter, r *http.Request){
     respondWithError(w, 500, "Error while creating user", err)
     return
   }
+  // The account exists either way; the user can ask for another mail.
+  if err := cfg.sendVerificationEmail(r.Context(), user); err != nil {
+    log.Printf("Error sending verification email to user %s: %v", user.ID, err)
+  }
   apiUser := User{
     ID: user.ID,
     CreatedAt: user.CreatedAt,
     UpdatedAt: user.UpdatedAt,
     Email: user.Email,
     IsChirpyRed: user.IsChirpyRed,
+    EmailVerified: user.EmailVerifiedAt.Valid,
   }
   respondWithJSON(w, 201, apiUser)
 }
 
+// updateUsersHandler replaces email and password. Like PATCH it takes the
+// current password.
 func (cfg *apiConfig) updateUsersHandler(w http.ResponseWriter, r *http.Request) {
 	reqBody := struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		CurrentPassword string `json:"current_password"`
 	}{}
-	accessToken, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get accessTokne /updateUsersHandler", err)
+	userId := middleware.FromContext(r.Context()).UserID
+
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error while decoding /updateUsersHandler", err)
 		return
 	}
-	userId, err := auth.ValidateJWT(accessToken, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate jwt", err)
+	// PUT replaces both, PATCH /api/users changes just one
+	if reqBody.Email == "" || reqBody.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "email and password are required", nil)
 		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error while decoding /updateUsersHandler", err)
+	current, err := cfg.db.GetUserById(r.Context(), userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if !cfg.checkCurrentPassword(w, r, current, reqBody.CurrentPassword) {
 		return
 	}
 
@@ -216,6 +294,7 @@ func (cfg *apiConfig) updateUsersHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// a new email is unverified until the user proves they own it
 	user, err := cfg.db.UpdateUserById(r.Context(), database.UpdateUserByIdParams{
 		Email:          reqBody.Email,
 		HashedPassword: hashedPass,
@@ -225,74 +304,358 @@ func (cfg *apiConfig) updateUsersHandler(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusInternalServerError, "couldn't update the user by id", err)
 		return
 	}
+	// A new password has to lock out whoever may have stolen the old one.
+	if err := cfg.revokeAllSessions(r.Context(), userId); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "couldn't revoke sessions", err)
+		return
+	}
+	cfg.audit(r, auditPasswordChanged, userId, userId, nil)
+	if user.Email != current.Email {
+		cfg.audit(r, auditEmailChanged, userId, userId, auditMeta{"from": current.Email, "to": user.Email})
+		if err := cfg.sendVerificationEmail(r.Context(), user); err != nil {
+			log.Printf("Error sending verification email to user %s: %v", user.ID, err)
+		}
+	}
   apiUser := User{
     CreatedAt: user.CreatedAt,
     UpdatedAt: user.UpdatedAt,
     Email: user.Email,
     ID: user.ID,
     IsChirpyRed: user.IsChirpyRed,
+    EmailVerified: user.EmailVerifiedAt.Valid,
   }
 
   respondWithJSON(w, http.StatusOK, apiUser)
 }
 
+// checkCurrentPassword answers 400 or 401 and returns false unless
+// password is user's current password. A stolen access token mustn't
+// become a way around the login throttle, so guesses count as failed
+// logins.
+func (cfg *apiConfig) checkCurrentPassword(w http.ResponseWriter, r *http.Request, user database.User, password string) bool {
+	if user.HashedPassword == "" {
+		respondWithError(w, http.StatusBadRequest, "set a password with a password reset first", nil)
+		return false
+	}
+	emailKey := strings.ToLower(strings.TrimSpace(user.Email))
+	ip := cfg.clientIP(r)
+	if cfg.loginThrottled(w, r, emailKey, ip) {
+		return false
+	}
+	if err := auth.CheckPasswordHash(password, user.HashedPassword); err != nil {
+		cfg.recordLoginFailure(r.Context(), emailKey, ip)
+		respondWithError(w, http.StatusUnauthorized, "incorrect current_password", err)
+		return false
+	}
+	return true
+}
+
+// patchUsersHandler changes only the fields sent. Email and password are
+// the keys to the account, so changing them takes the current password.
+func (cfg *apiConfig) patchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.FromContext(r.Context())
+	reqBody := struct {
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if reqBody.Email == nil && reqBody.Password == nil {
+		respondWithError(w, http.StatusBadRequest, "nothing to update", nil)
+		return
+	}
+	user, err := cfg.db.GetUserById(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if !cfg.checkCurrentPassword(w, r, user, reqBody.CurrentPassword) {
+		return
+	}
+
+	params := database.PatchUserParams{ID: user.ID}
+	if reqBody.Email != nil {
+		email := strings.TrimSpace(*reqBody.Email)
+		if email == "" {
+			respondWithError(w, http.StatusBadRequest, "email can't be empty", nil)
+			return
+		}
+		if email != user.Email {
+			params.Email = sql.NullString{String: email, Valid: true}
+		}
+	}
+	if reqBody.Password != nil {
+		if *reqBody.Password == "" {
+			respondWithError(w, http.StatusBadRequest, "password can't be empty", nil)
+			return
+		}
+		hashedPass, err := auth.HashPassword(*reqBody.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "couldn't hash password", err)
+			return
+		}
+		params.HashedPassword = sql.NullString{String: hashedPass, Valid: true}
+	}
+	updated, err := cfg.db.PatchUser(r.Context(), params)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		respondWithError(w, http.StatusConflict, "email is already in use", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "couldn't update the user", err)
+		return
+	}
+
+	if params.Email.Valid {
+		cfg.audit(r, auditEmailChanged, principal.UserID, user.ID, auditMeta{"from": user.Email, "to": updated.Email})
+		if err := cfg.sendVerificationEmail(r.Context(), updated); err != nil {
+			log.Printf("Error sending verification email to user %s: %v", updated.ID, err)
+		}
+	}
+	if params.HashedPassword.Valid {
+		// whoever may have the old password loses their sessions, the
+		// caller keeps theirs
+		currentSessionID, _ := uuid.Parse(principal.SessionID)
+		families, err := cfg.db.RevokeOtherSessions(r.Context(), database.RevokeOtherSessionsParams{
+			UserID:   user.ID,
+			FamilyID: currentSessionID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "couldn't revoke sessions", err)
+			return
+		}
+		if err := cfg.denySessions(r.Context(), families); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "couldn't revoke access tokens", err)
+			return
+		}
+		cfg.audit(r, auditPasswordChanged, principal.UserID, user.ID, nil)
+	}
+
+	respondWithJSON(w, http.StatusOK, User{
+		ID:            updated.ID,
+		CreatedAt:     updated.CreatedAt,
+		UpdatedAt:     updated.UpdatedAt,
+		Email:         updated.Email,
+		IsChirpyRed:   updated.IsChirpyRed,
+		EmailVerified: updated.EmailVerifiedAt.Valid,
+	})
+}
+
 func (cfg *apiConfig) loginHandler(w http.ResponseWriter, r *http.Request){
 	reqBody := struct {
 	Email            string `json:"email"`
 	Password         string `json:"password"`
+	DeviceLabel      string `json:"device_label"`
 }{}
   if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
     respondWithError(w, http.StatusInternalServerError, "Error decoding r.body", err)
     return
   }
+  emailKey := strings.ToLower(strings.TrimSpace(reqBody.Email))
+  ip := cfg.clientIP(r)
+  if cfg.loginThrottled(w, r, emailKey, ip) {
+    return
+  }
   user, err := cfg.db.GetUserByEmail(r.Context(), reqBody.Email)
   if err != nil {
     log.Printf("Error fetching user: %v", err)
+    cfg.recordLoginFailure(r.Context(), emailKey, ip)
+    cfg.audit(r, auditLoginFailed, uuid.Nil, uuid.Nil, auditMeta{"email": emailKey, "reason": "unknown email"})
     respondWithError(w, http.StatusUnauthorized, "incorrect email or password", err)
     return
   }
 
   if err := auth.CheckPasswordHash(reqBody.Password, user.HashedPassword); err != nil {
     log.Printf("Password mismatch for user: %s", reqBody.Email)
+    cfg.recordLoginFailure(r.Context(), emailKey, ip)
+    cfg.audit(r, auditLoginFailed, uuid.Nil, user.ID, auditMeta{"email": emailKey, "reason": "wrong password"})
     respondWithError(w, http.StatusUnauthorized, "incorrect email or password", err)
     return
   }
+  if err := cfg.emailThrottle.Reset(r.Context(), emailKey); err != nil {
+    log.Printf("Error resetting failed logins for %s: %v", emailKey, err)
+  }
+  cfg.rehashPassword(r.Context(), user, reqBody.Password)
 
-  accessToken, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+  twoFactor, err := cfg.twoFactorEnabled(r.Context(), user.ID)
   if err != nil {
-    respondWithError(w, http.StatusInternalServerError, "Error generating accessToken", err)
+    respondWithError(w, http.StatusInternalServerError, "Couldn't look up 2fa", err)
     return
   }
-
-  refreshToken, err := auth.MakeRefreshToken()
-  if err != nil {
-    respondWithError(w, http.StatusInternalServerError, "Error getting refreshToken", err)
-    return 
+  if twoFactor {
+    cfg.respondWithTwoFactorChallenge(w, user)
+    return
   }
+  cfg.respondWithSession(w, r, user, reqBody.DeviceLabel, "password")
+}
 
-  _, err = cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-    Token: refreshToken,
-    UserID: user.ID,
-    ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
-  })
-
+// respondWithSession starts a new session for user and answers with the
+// access and refresh token pair, or sets them as cookies in cookie mode.
+// Every way of logging in ends here. deviceLabel names the session in
+// /api/sessions, when empty it is made up from the User-Agent. method is how
+// the user proved who they are, for the audit log.
+func (cfg *apiConfig) respondWithSession(w http.ResponseWriter, r *http.Request, user database.User, deviceLabel, method string) {
+  if user.DeleteAfter.Valid {
+    if err := cfg.cancelDeletion(r, user); err != nil {
+      respondWithError(w, http.StatusInternalServerError, "Couldn't cancel account deletion", err)
+      return
+    }
+  }
+  tokens, err := cfg.startSession(r, user.ID, deviceLabel, sql.NullString{}, nil)
   if err != nil {
-    respondWithError(w, http.StatusInternalServerError, "Couldn't save refreshToken", err)
+    respondWithError(w, http.StatusInternalServerError, "Couldn't start session", err)
     return
   }
+  cfg.audit(r, auditLogin, user.ID, user.ID, auditMeta{"method": method, "device_label": deviceLabel})
 
   apiUser := User{
     ID: user.ID,
     CreatedAt: user.CreatedAt,
     UpdatedAt: user.UpdatedAt,
     Email: user.Email,
-    Token: accessToken,
-    RefreshToken: refreshToken,
+    Token: tokens.AccessToken,
+    RefreshToken: tokens.RefreshToken,
     IsChirpyRed: user.IsChirpyRed,
+    EmailVerified: user.EmailVerifiedAt.Valid,
+  }
+  if cookieMode(r) {
+    if err := cfg.setSessionCookies(w, tokens); err != nil {
+      respondWithError(w, http.StatusInternalServerError, "Couldn't set session cookies", err)
+      return
+    }
+    apiUser.Token = ""
+    apiUser.RefreshToken = ""
   }
   respondWithJSON(w, http.StatusOK, apiUser)
 }
 
+// sessionTokens is what logging in or refreshing hands out. RefreshToken
+// is the raw token for the client, Session what the database keeps of it.
+type sessionTokens struct {
+  AccessToken string
+  AccessTokenTTL time.Duration
+  RefreshToken string
+  Session database.RefreshToken
+}
+
+// startSession opens a new refresh token family and returns its first
+// refresh token along with an access token. Sessions of OAuth clients carry
+// the client and the scopes the user granted it.
+func (cfg *apiConfig) startSession(r *http.Request, userID uuid.UUID, deviceLabel string, clientID sql.NullString, scopes []string) (sessionTokens, error) {
+  user, err := cfg.db.GetUserById(r.Context(), userID)
+  if err != nil {
+    return sessionTokens{}, err
+  }
+  refreshToken, err := auth.MakeRefreshToken()
+  if err != nil {
+    return sessionTokens{}, err
+  }
+  if deviceLabel == "" {
+    deviceLabel = describeUserAgent(r.UserAgent())
+  }
+  now := time.Now().UTC()
+  token, err := cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+    TokenHash: auth.HashRefreshToken(refreshToken, cfg.refreshTokenKey),
+    UserID: userID,
+    ExpiresAt: cfg.sessions.forRole(user.Role).refreshExpiry(now, now),
+    FamilyID: uuid.New(),
+    SessionStartedAt: now,
+    UserAgent: r.UserAgent(),
+    IpAddress: cfg.clientIP(r),
+    DeviceLabel: deviceLabel,
+    ClientID: clientID,
+    Scopes: scopes,
+  })
+  if err != nil {
+    return sessionTokens{}, err
+  }
+  return cfg.issueTokens(refreshToken, token, user)
+}
+
+// issueTokens mints an access token to go with refreshToken, the new
+// refresh token of a session. The role and Chirpy Red come from user, which callers read
+// afresh, so changes show up in the session's next access token.
+func (cfg *apiConfig) issueTokens(refreshToken string, token database.RefreshToken, user database.User) (sessionTokens, error) {
+  policy := cfg.sessions.forRole(user.Role)
+  accessToken, err := auth.MakeAccessToken(auth.AccessToken{
+    UserID: token.UserID,
+    SessionID: token.FamilyID,
+    Role: user.Role,
+    ChirpyRed: user.IsChirpyRed,
+    ClientID: token.ClientID.String,
+    Scopes: token.Scopes,
+  }, cfg.jwtKeys, policy.AccessTokenTTL)
+  if err != nil {
+    return sessionTokens{}, err
+  }
+  return sessionTokens{
+    AccessToken: accessToken,
+    AccessTokenTTL: policy.AccessTokenTTL,
+    RefreshToken: refreshToken,
+    Session: token,
+  }, nil
+}
+
+// loginThrottled answers 429 when the account or the client IP is locked
+// out. The lockout holds even for the right password, otherwise it would
+// just tell an attacker which guess was correct.
+func (cfg *apiConfig) loginThrottled(w http.ResponseWriter, r *http.Request, emailKey, ip string) bool {
+  emailWait, err := cfg.emailThrottle.Check(r.Context(), emailKey)
+  if err != nil {
+    respondWithError(w, http.StatusInternalServerError, "Couldn't check failed logins", err)
+    return true
+  }
+  ipWait, err := cfg.ipThrottle.Check(r.Context(), ip)
+  if err != nil {
+    respondWithError(w, http.StatusInternalServerError, "Couldn't check failed logins", err)
+    return true
+  }
+  wait := max(emailWait, ipWait)
+  if wait == 0 {
+    return false
+  }
+  cfg.audit(r, auditLoginFailed, uuid.Nil, uuid.Nil, auditMeta{"email": emailKey, "reason": "throttled"})
+  respondWithRetryAfter(w, wait, "too many failed logins, try again later")
+  return true
+}
+
+// recordLoginFailure counts a failed login against both the account and the
+// client IP. Errors are only logged, the caller answers 401 either way.
+func (cfg *apiConfig) recordLoginFailure(ctx context.Context, emailKey, ip string) {
+  if _, err := cfg.emailThrottle.Fail(ctx, emailKey); err != nil {
+    log.Printf("Error recording failed login for %s: %v", emailKey, err)
+  }
+  if _, err := cfg.ipThrottle.Fail(ctx, ip); err != nil {
+    log.Printf("Error recording failed login from %s: %v", ip, err)
+  }
+}
+
+// rehashPassword moves a user off bcrypt or outdated argon2id parameters
+// while we still hold their plain password. Failing here must not fail the
+// login, the old hash keeps working.
+func (cfg *apiConfig) rehashPassword(ctx context.Context, user database.User, password string) {
+  if !auth.NeedsRehash(user.HashedPassword) {
+    return
+  }
+  newHash, err := auth.HashPassword(password)
+  if err != nil {
+    log.Printf("Error rehashing password for user %s: %v", user.ID, err)
+    return
+  }
+  _, err = cfg.db.RehashUserPassword(ctx, database.RehashUserPasswordParams{
+    NewHash: newHash,
+    ID: user.ID,
+    OldHash: user.HashedPassword,
+  })
+  if err != nil {
+    log.Printf("Error saving rehashed password for user %s: %v", user.ID, err)
+  }
+}
+
 func (cfg *apiConfig) getAllChirpsHandler(w http.ResponseWriter, r *http.Request){
   s := r.URL.Query().Get("author_id")
   chirpSlice := []database.Chirp{}
@@ -344,45 +707,187 @@ func (cfg *apiConfig) getAllChirpsHandler(w http.ResponseWriter, r *http.Request
 }
 
 func (cfg *apiConfig) refreshHandler (w http.ResponseWriter, r *http.Request){
-  refreshToken, err := auth.GetBearerToken(r.Header)
+  refreshToken, fromCookie, err := refreshTokenFromRequest(r)
+  if errors.Is(err, middleware.ErrCSRF) {
+    respondWithError(w, http.StatusForbidden, err.Error(), nil)
+    return
+  }
   if err != nil {
     respondWithError(w, http.StatusBadRequest, "Couldn't find refreshToken", err)
     return
   }
-  user, err := cfg.db.GetUserFromRefreshToken(r.Context(), refreshToken)
+  oldToken, err := cfg.lookupRefreshToken(r.Context(), refreshToken)
   if err != nil {
     respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refreshToken", err)
     return
   }
-  accessToken, err := auth.MakeJWT(user.ID, 
-  cfg.jwtSecret, time.Hour)
+  // OAuth clients refresh through /oauth/token, which keeps their scopes
+  if oldToken.ClientID.Valid {
+    respondWithError(w, http.StatusUnauthorized, "refreshToken belongs to an OAuth client", nil)
+    return
+  }
+  tokens, err := cfg.rotateRefreshToken(r, oldToken)
+  if errors.Is(err, errRefreshTokenReused) || errors.Is(err, errRefreshTokenExpired) {
+    respondWithError(w, http.StatusUnauthorized, err.Error(), nil)
+    return
+  }
   if err != nil {
-    respondWithError(w, http.StatusUnauthorized, "Couldn't make jwt in /refresh", err)
+    respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refreshToken", err)
+    return
+  }
+  newToken := tokens.Session
+  cfg.audit(r, auditTokenRefreshed, newToken.UserID, newToken.UserID, auditMeta{"session_id": newToken.FamilyID})
+  if fromCookie {
+    if err := cfg.setSessionCookies(w, tokens); err != nil {
+      respondWithError(w, http.StatusInternalServerError, "Couldn't set session cookies", err)
+      return
+    }
+    w.WriteHeader(http.StatusNoContent)
     return
   }
 
 type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
   respondWithJSON(w, http.StatusOK, response{
-    Token: accessToken,
+    Token: tokens.AccessToken,
+    RefreshToken: tokens.RefreshToken,
   })
 }
 
+var (
+  errRefreshTokenReused = errors.New("refreshToken reuse detected")
+  errRefreshTokenExpired = errors.New("refreshToken is revoked or expired")
+)
+
+// rotateRefreshToken exchanges oldToken for the next token of its family
+// and a new access token. The new refresh token's idle timeout starts over,
+// but never past the session's maximum age.
+func (cfg *apiConfig) rotateRefreshToken(r *http.Request, oldToken database.RefreshToken) (sessionTokens, error) {
+  // A token that was already exchanged is being replayed, so either the
+  // client or an attacker holds a stolen copy. Kill the whole family.
+  if oldToken.RotatedAt.Valid {
+    return sessionTokens{}, cfg.revokeRefreshTokenFamily(r, oldToken)
+  }
+  user, err := cfg.db.GetUserById(r.Context(), oldToken.UserID)
+  if err != nil {
+    return sessionTokens{}, err
+  }
+  // the policy may have tightened since the token was issued
+  now := time.Now().UTC()
+  expiresAt := cfg.sessions.forRole(user.Role).refreshExpiry(oldToken.SessionStartedAt, now)
+  if oldToken.RevokedAt.Valid || now.After(oldToken.ExpiresAt) || now.After(expiresAt) {
+    return sessionTokens{}, errRefreshTokenExpired
+  }
+  rotated, err := cfg.db.RotateRefreshToken(r.Context(), oldToken.TokenHash)
+  if err != nil {
+    return sessionTokens{}, err
+  }
+  if rotated == 0 {
+    // lost the race against a concurrent refresh with the same token
+    return sessionTokens{}, cfg.revokeRefreshTokenFamily(r, oldToken)
+  }
+
+  newRefreshToken, err := auth.MakeRefreshToken()
+  if err != nil {
+    return sessionTokens{}, err
+  }
+  newToken, err := cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+    TokenHash: auth.HashRefreshToken(newRefreshToken, cfg.refreshTokenKey),
+    UserID: oldToken.UserID,
+    ExpiresAt: expiresAt,
+    FamilyID: oldToken.FamilyID,
+    ParentTokenHash: sql.NullString{String: oldToken.TokenHash, Valid: true},
+    SessionStartedAt: oldToken.SessionStartedAt,
+    UserAgent: r.UserAgent(),
+    IpAddress: cfg.clientIP(r),
+    DeviceLabel: oldToken.DeviceLabel,
+    ClientID: oldToken.ClientID,
+    Scopes: oldToken.Scopes,
+  })
+  if err != nil {
+    return sessionTokens{}, err
+  }
+  return cfg.issueTokens(newRefreshToken, newToken, user)
+}
+
+// revokeRefreshTokenFamily shuts down the session of a replayed token and
+// returns errRefreshTokenReused once it's done.
+func (cfg *apiConfig) revokeRefreshTokenFamily(r *http.Request, reused database.RefreshToken) error {
+  log.Printf("refreshToken reuse detected for user %s, revoking family %s", reused.UserID, reused.FamilyID)
+  if err := cfg.db.RevokeRefreshTokenFamily(r.Context(), reused.FamilyID); err != nil {
+    return err
+  }
+  if err := cfg.denylist.DenySession(r.Context(), reused.FamilyID); err != nil {
+    return err
+  }
+  cfg.audit(r, auditTokenReuse, uuid.Nil, reused.UserID, auditMeta{"session_id": reused.FamilyID})
+  return errRefreshTokenReused
+}
+
+// revokeAllSessions logs the user out everywhere: every refresh token is
+// revoked and every access token minted from them is denied.
+func (cfg *apiConfig) revokeAllSessions(ctx context.Context, userID uuid.UUID) error {
+  families, err := cfg.db.RevokeAllRefreshTokensForUser(ctx, userID)
+  if err != nil {
+    return err
+  }
+  return cfg.denySessions(ctx, families)
+}
+
+// denySessions puts the families revoked by a bulk update on the denylist.
+// Every token of a family comes back, so they need deduplicating first.
+func (cfg *apiConfig) denySessions(ctx context.Context, families []uuid.UUID) error {
+  seen := map[uuid.UUID]bool{}
+  for _, family := range families {
+    if seen[family] {
+      continue
+    }
+    seen[family] = true
+    if err := cfg.denylist.DenySession(ctx, family); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
 func (cfg *apiConfig) revokeHandler(w http.ResponseWriter, r *http.Request){
-  refreshToken, err:= auth.GetBearerToken(r.Header)
+  refreshToken, fromCookie, err := refreshTokenFromRequest(r)
+  if errors.Is(err, middleware.ErrCSRF) {
+    respondWithError(w, http.StatusForbidden, err.Error(), nil)
+    return
+  }
   if err != nil {
     respondWithError(w, http.StatusBadRequest, "Couldn't get refreshToken /revoke", err)
     return
   }
-  _, err = cfg.db.RevokeRefreshToken(r.Context(), refreshToken)
+  if fromCookie {
+    cfg.clearSessionCookies(w)
+  }
+  token, err := cfg.lookupRefreshToken(r.Context(), refreshToken)
   if err != nil {
     respondWithError(w, http.StatusInternalServerError, "couldn't revoke refreshToken", err)
     return
   }
+  revoked, err := cfg.db.RevokeRefreshToken(r.Context(), token.TokenHash)
+  if err != nil {
+    respondWithError(w, http.StatusInternalServerError, "couldn't revoke refreshToken", err)
+    return
+  }
+  if err := cfg.denylist.DenySession(r.Context(), revoked.FamilyID); err != nil {
+    respondWithError(w, http.StatusInternalServerError, "couldn't revoke access tokens", err)
+    return
+  }
+  cfg.audit(r, auditSessionRevoked, revoked.UserID, revoked.UserID, auditMeta{"session_id": revoked.FamilyID})
   w.WriteHeader(http.StatusNoContent)
 }
 
+func (cfg *apiConfig) jwksHandler(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Cache-Control", "public, max-age=300")
+  respondWithJSON(w, http.StatusOK, cfg.jwtKeys.JWKS())
+}
+
 func (cfg *apiConfig) getChirpsByIdHandler(w http.ResponseWriter, r *http.Request){
   chirpIdStr := r.PathValue("chirpID")
   chirpId, err := uuid.Parse(chirpIdStr) 
@@ -406,16 +911,8 @@ func (cfg *apiConfig) getChirpsByIdHandler(w http.ResponseWriter, r *http.Reques
 }
 
 func (cfg *apiConfig) deleteChirpsByIdHandler(w http.ResponseWriter, r *http.Request){
-  accessToken, err := auth.GetBearerToken(r.Header)
-  if err != nil {
-    respondWithError(w, http.StatusUnauthorized, "Couldn't get accessToken", err)
-    return
-  }
-  userId, err := auth.ValidateJWT(accessToken, cfg.jwtSecret)
-  if err != nil {
-    respondWithError(w, http.StatusUnauthorized, "Couldn't validate jwt", err)
-    return
-  }
+  principal := middleware.FromContext(r.Context())
+  userId := principal.UserID
 
   chirpIdStr := r.PathValue("chirpID")
   chirpId, err := uuid.Parse(chirpIdStr) 
@@ -429,7 +926,8 @@ func (cfg *apiConfig) deleteChirpsByIdHandler(w http.ResponseWriter, r *http.Req
     return
   }
 
-  if chirp.UserID != userId {
+  // moderators may take down anyone's chirps
+  if chirp.UserID != userId && !principal.HasPermission(auth.PermModerateChirps) {
     respondWithError(w, http.StatusForbidden, "user is not authorised to perform this action", err)
     return
   }
@@ -465,6 +963,15 @@ func (cfg *apiConfig) webhooksHandler(w http.ResponseWriter, r *http.Request) {
     w.WriteHeader(http.StatusNoContent)
     return
   }
+  oldUser, err := cfg.db.GetUserById(r.Context(), reqBody.Data.UserId)
+  if errors.Is(err, sql.ErrNoRows){
+    respondWithError(w, http.StatusNotFound, "couldn't fing the user", err)
+    return
+  }
+  if err != nil {
+    respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+    return
+  }
   _, err = cfg.db.UpgradeToChirpyRed(r.Context(), reqBody.Data.UserId)
   if errors.Is(err, sql.ErrNoRows){
     respondWithError(w, http.StatusNotFound, "couldn't fing the user", err)
@@ -474,31 +981,336 @@ func (cfg *apiConfig) webhooksHandler(w http.ResponseWriter, r *http.Request) {
     respondWithError(w, http.StatusInternalServerError, "Couldn't upgrade to chirpy red", err)
     return
   }
+  cfg.audit(r, auditChirpyRedUpgrade, uuid.Nil, reqBody.Data.UserId, auditMeta{"source": "polka"})
+  // access tokens say is_chirpy_red as it was when they were minted, end
+  // the sessions so none goes on saying false
+  if !oldUser.IsChirpyRed {
+    if err := cfg.revokeAllSessions(r.Context(), reqBody.Data.UserId); err != nil {
+      respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+      return
+    }
+  }
   
   w.WriteHeader(http.StatusNoContent)
 }
 
+// outboxDir is where the file mailer writes to. The mail holds login and
+// password reset links, so it must not be somewhere /app/ serves: the
+// default is outside the working directory, and so must MAILER_DIR be.
+func outboxDir(dir string) (string, error) {
+  if dir == "" {
+    return filepath.Join(os.TempDir(), "chirpy-outbox"), nil
+  }
+  abs, err := filepath.Abs(dir)
+  if err != nil {
+    return "", err
+  }
+  wd, err := os.Getwd()
+  if err != nil {
+    return "", err
+  }
+  if rel, err := filepath.Rel(wd, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+    return "", fmt.Errorf("%s is inside the directory served at /app/", dir)
+  }
+  return abs, nil
+}
+
 func main() {
+  makeAdminEmail := flag.String("make-admin", "", "promote the user with this email to admin and exit")
+  flag.Parse()
   godotenv.Load()
   dbURL := os.Getenv("DB_URL")
   platf := os.Getenv("PLATFORM")
   jwtS := os.Getenv("JWTSECRET")
+  jwtKeysDir := os.Getenv("JWT_KEYS_DIR")
+  jwtActiveKID := os.Getenv("JWT_ACTIVE_KID")
   polkaK := os.Getenv("POLKA_KEY")
   if dbURL == "" {
     log.Fatal("DB_URL must be set")
   }
+  argonParams := auth.DefaultArgon2idParams
+  if v := os.Getenv("ARGON2_MEMORY_KIB"); v != "" {
+    n, err := strconv.ParseUint(v, 10, 32)
+    if err != nil {
+      log.Fatalf("Invalid ARGON2_MEMORY_KIB: %s", err)
+    }
+    argonParams.Memory = uint32(n)
+  }
+  if v := os.Getenv("ARGON2_ITERATIONS"); v != "" {
+    n, err := strconv.ParseUint(v, 10, 32)
+    if err != nil {
+      log.Fatalf("Invalid ARGON2_ITERATIONS: %s", err)
+    }
+    argonParams.Iterations = uint32(n)
+  }
+  if v := os.Getenv("ARGON2_PARALLELISM"); v != "" {
+    n, err := strconv.ParseUint(v, 10, 8)
+    if err != nil {
+      log.Fatalf("Invalid ARGON2_PARALLELISM: %s", err)
+    }
+    argonParams.Parallelism = uint8(n)
+  }
+  if err := argonParams.Validate(); err != nil {
+    log.Fatalf("Invalid ARGON2_* settings: %s", err)
+  }
+  auth.SetArgon2idParams(argonParams)
+  jwtOptions := auth.DefaultJWTOptions
+  if v := os.Getenv("JWT_ISSUER"); v != "" {
+    jwtOptions.Issuer = v
+  }
+  if v := os.Getenv("JWT_AUDIENCE"); v != "" {
+    jwtOptions.Audience = v
+  }
+  if v := os.Getenv("JWT_LEEWAY"); v != "" {
+    d, err := time.ParseDuration(v)
+    if err != nil || d < 0 {
+      log.Fatalf("Invalid JWT_LEEWAY %q, want a duration like 30s", v)
+    }
+    jwtOptions.Leeway = d
+  }
+  sessions, err := loadSessionPolicies()
+  if err != nil {
+    log.Fatalf("Invalid session policy: %s", err)
+  }
+  // tokens from before audiences were checked keep working until they
+  // would have expired anyway. JWT_AUDIENCE_SINCE pins when that was, so
+  // a restart doesn't move it; the default is now, for the first start of
+  // the upgrade.
+  jwtOptions.MissingAudienceBefore = time.Now()
+  if v := os.Getenv("JWT_AUDIENCE_SINCE"); v != "" {
+    t, err := time.Parse(time.RFC3339, v)
+    if err != nil {
+      log.Fatalf("Invalid JWT_AUDIENCE_SINCE %q, want a time like 2026-10-17T00:00:00Z", v)
+    }
+    jwtOptions.MissingAudienceBefore = t
+  }
+  jwtOptions.MissingAudienceMaxAge = sessions.maxAccessTokenTTL()
+  auth.SetJWTOptions(jwtOptions)
   dbConn, err := sql.Open("postgres", dbURL)
   if err != nil {
 		log.Fatalf("Error opening database: %s", err)
   }
   dbQueries := database.New(dbConn)
+
+  // The first admin has to come from outside the API: run once with
+  // -make-admin, or keep ADMIN_EMAIL set for the account to be promoted on
+  // every start.
+  if *makeAdminEmail != "" {
+    user, err := makeAdmin(context.Background(), dbQueries, *makeAdminEmail)
+    if errors.Is(err, sql.ErrNoRows) {
+      log.Fatalf("No verified user with email %s, sign up and verify the email first", *makeAdminEmail)
+    }
+    if err != nil {
+      log.Fatalf("Error promoting %s to admin: %s", *makeAdminEmail, err)
+    }
+    log.Printf("%s (%s) is now an admin", user.Email, user.ID)
+    return
+  }
+  if email := os.Getenv("ADMIN_EMAIL"); email != "" {
+    _, err := makeAdmin(context.Background(), dbQueries, email)
+    if errors.Is(err, sql.ErrNoRows) {
+      log.Printf("ADMIN_EMAIL %s doesn't belong to a verified user yet, sign up, verify the email and restart", email)
+    } else if err != nil {
+      log.Fatalf("Error promoting ADMIN_EMAIL to admin: %s", err)
+    }
+  }
+
+  deletionGrace := defaultDeletionGrace
+  if v := os.Getenv("ACCOUNT_DELETION_GRACE"); v != "" {
+    d, err := time.ParseDuration(v)
+    if err != nil || d < 0 {
+      log.Fatalf("Invalid ACCOUNT_DELETION_GRACE %q, want a duration like 720h", v)
+    }
+    deletionGrace = d
+  }
+
+  publicURL := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+  if publicURL == "" {
+    publicURL = "http://localhost:8080"
+  }
+  // Passkeys only work on the site they were created for, so changing
+  // PUBLIC_URL's host makes every registered passkey useless.
+  rpOrigin, err := url.Parse(publicURL)
+  if err != nil || rpOrigin.Host == "" {
+    log.Fatalf("Invalid PUBLIC_URL %q", publicURL)
+  }
+  relyingParty := &webauthn.RelyingParty{
+    ID: rpOrigin.Hostname(),
+    Name: "Chirpy",
+    Origin: rpOrigin.Scheme + "://" + rpOrigin.Host,
+  }
+  mailFrom := os.Getenv("MAIL_FROM")
+  if mailFrom == "" {
+    mailFrom = "Chirpy <no-reply@localhost>"
+  }
+  var accountMailer mailer.Mailer
+  switch os.Getenv("MAILER") {
+  case "smtp":
+    accountMailer = &mailer.SMTP{
+      Addr: os.Getenv("SMTP_ADDR"),
+      Username: os.Getenv("SMTP_USERNAME"),
+      Password: os.Getenv("SMTP_PASSWORD"),
+      From: mailFrom,
+    }
+  case "file":
+    dir, err := outboxDir(os.Getenv("MAILER_DIR"))
+    if err != nil {
+      log.Fatalf("Invalid MAILER_DIR: %s", err)
+    }
+    accountMailer = &mailer.File{Dir: dir, From: mailFrom}
+  case "memory":
+    accountMailer = &mailer.Memory{}
+  case "":
+    if platf != "dev" {
+      log.Fatal("MAILER must be set to smtp, file or memory")
+    }
+    dir, err := outboxDir("")
+    if err != nil {
+      log.Fatalf("Invalid MAILER_DIR: %s", err)
+    }
+    log.Printf("Writing mail to %s", dir)
+    accountMailer = &mailer.File{Dir: dir, From: mailFrom}
+  default:
+    log.Fatalf("Unknown MAILER %q, want smtp, file or memory", os.Getenv("MAILER"))
+  }
+
+  // a denied session has to stay on the list as long as any of its access
+  // tokens could still be accepted, impersonation tokens included
+  tokenDenylist := denylist.New(dbQueries, max(sessions.maxAccessTokenTTL(), impersonationTTL)+jwtOptions.Leeway)
+  if err := tokenDenylist.Sync(context.Background()); err != nil {
+    log.Fatalf("Error loading access token denylist: %s", err)
+  }
+  go tokenDenylist.Run(context.Background(), 15*time.Second)
+
+  // A single account gets few guesses. An IP gets more because offices and
+  // mobile carriers put many users behind one address.
+  emailThrottle := throttle.New(dbQueries, "email", throttle.Policy{
+    FreeAttempts: 5,
+    BaseLockout: 30 * time.Second,
+    MaxLockout: 15 * time.Minute,
+    Window: time.Hour,
+  })
+  ipThrottle := throttle.New(dbQueries, "ip", throttle.Policy{
+    FreeAttempts: 20,
+    BaseLockout: 30 * time.Second,
+    MaxLockout: time.Hour,
+    Window: time.Hour,
+  })
+  twoFactorThrottle := throttle.New(dbQueries, "2fa", throttle.Policy{
+    FreeAttempts: 5,
+    BaseLockout: 30 * time.Second,
+    MaxLockout: 15 * time.Minute,
+    Window: time.Hour,
+  })
+  // Not failures, but every login link mailed to an address: a few are
+  // fine, a stream of them is someone flooding the inbox.
+  magicLinkThrottle := throttle.New(dbQueries, "magic", throttle.Policy{
+    FreeAttempts: 3,
+    BaseLockout: time.Minute,
+    MaxLockout: time.Hour,
+    Window: time.Hour,
+  })
+  passwordResetThrottle := throttle.New(dbQueries, "reset", throttle.Policy{
+    FreeAttempts: 3,
+    BaseLockout: time.Minute,
+    MaxLockout: time.Hour,
+    Window: time.Hour,
+  })
+  deviceCodeThrottle := throttle.New(dbQueries, "device", throttle.Policy{
+    FreeAttempts: 5,
+    BaseLockout: 30 * time.Second,
+    MaxLockout: 15 * time.Minute,
+    Window: time.Hour,
+  })
+  go emailThrottle.Run(context.Background(), 10*time.Minute)
+  go ipThrottle.Run(context.Background(), 10*time.Minute)
+  go twoFactorThrottle.Run(context.Background(), 10*time.Minute)
+  go magicLinkThrottle.Run(context.Background(), 10*time.Minute)
+  go passwordResetThrottle.Run(context.Background(), 10*time.Minute)
+  go deviceCodeThrottle.Run(context.Background(), 10*time.Minute)
+
+  jwtKeys := auth.NewKeySet()
+  if jwtS != "" {
+    jwtKeys.AddHMAC([]byte(jwtS))
+  }
+  if jwtKeysDir != "" {
+    if err := jwtKeys.LoadDir(jwtKeysDir, jwtActiveKID); err != nil {
+      log.Fatalf("Error loading JWT keys: %s", err)
+    }
+    // Rotate by dropping a new key file in JWT_KEYS_DIR and sending SIGHUP.
+    // Keep the old file until every token it signed has expired.
+    go func() {
+      hup := make(chan os.Signal, 1)
+      signal.Notify(hup, syscall.SIGHUP)
+      for range hup {
+        if err := jwtKeys.LoadDir(jwtKeysDir, jwtActiveKID); err != nil {
+          log.Printf("Error reloading JWT keys, keeping the current ones: %s", err)
+          continue
+        }
+        log.Printf("Reloaded JWT keys from %s", jwtKeysDir)
+      }
+    }()
+  } else if jwtS == "" {
+    log.Fatal("JWTSECRET or JWT_KEYS_DIR must be set")
+  }
+
+  // Changing the key logs everyone out, and so does changing JWTSECRET
+  // while the key is derived from it.
+  var refreshTokenKey []byte
+  if v := os.Getenv("REFRESH_TOKEN_KEY"); v != "" {
+    refreshTokenKey = []byte(v)
+  } else if jwtS != "" {
+    refreshTokenKey = auth.DeriveKey([]byte(jwtS), "chirpy refresh tokens")
+  } else {
+    log.Fatal("REFRESH_TOKEN_KEY must be set when JWTSECRET isn't")
+  }
+
+  var oidcProvider *oidc.Provider
+  if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+    redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+    if redirectURL == "" {
+      redirectURL = publicURL + "/app/oidc-callback.html"
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+    oidcProvider, err = oidc.Discover(ctx, issuer, os.Getenv("OIDC_CLIENT_ID"), os.Getenv("OIDC_CLIENT_SECRET"), redirectURL)
+    cancel()
+    if err != nil {
+      log.Fatalf("Error discovering OIDC provider: %s", err)
+    }
+  }
+
   apiCfg := apiConfig{
 		fileserverHits: atomic.Int32{},
 		db:             dbQueries,
     platform:       platf,
-    jwtSecret: jwtS,
+    jwtKeys: jwtKeys,
+    refreshTokenKey: refreshTokenKey,
+    denylist: tokenDenylist,
+    emailThrottle: emailThrottle,
+    ipThrottle: ipThrottle,
+    twoFactorThrottle: twoFactorThrottle,
+    magicLinkThrottle: magicLinkThrottle,
+    passwordResetThrottle: passwordResetThrottle,
+    deviceCodeThrottle: deviceCodeThrottle,
+    trustProxy: os.Getenv("TRUST_PROXY_HEADERS") == "true",
+    mailer: accountMailer,
+    publicURL: publicURL,
+    deletionGrace: deletionGrace,
+    sessions: sessions,
+    oidc: oidcProvider,
+    webauthn: relyingParty,
     polkakey: polkaK,
 	}
+  go apiCfg.runAccountPurge(context.Background(), time.Hour)
+  go apiCfg.hashLegacyRefreshTokens(context.Background())
+
+	authn := &middleware.Authenticator{
+		Keys:             jwtKeys,
+		Denylist:         tokenDenylist,
+		APITokens:        dbQueries,
+		RespondWithError: respondWithError,
+		OnImpersonation:  apiCfg.logImpersonatedRequest,
+	}
 
 	const port = "8080"
 	mux := http.NewServeMux()
@@ -510,10 +1322,31 @@ func main() {
 
 	mux.Handle("/app/", apiCfg.middlewareMetricsInc(http.StripPrefix("/app/", http.FileServer(http.Dir(".")))))
 
-	mux.HandleFunc("GET /admin/metrics", apiCfg.metricsHandler)
-	mux.HandleFunc("POST /admin/reset", apiCfg.resetHandler)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.jwksHandler)
+	mux.HandleFunc("GET /.well-known/oauth-authorization-server", apiCfg.oauthMetadataHandler)
+
+	mux.HandleFunc("GET /oauth/authorize", apiCfg.oauthAuthorizeHandler)
+	mux.HandleFunc("POST /oauth/device_authorization", apiCfg.deviceAuthorizationHandler)
+	mux.HandleFunc("POST /oauth/token", apiCfg.oauthTokenHandler)
+	mux.HandleFunc("POST /oauth/revoke", apiCfg.oauthRevokeHandler)
+
+	mux.Handle("GET /admin/metrics", authn.RequirePermission(auth.PermViewMetrics, apiCfg.metricsHandler))
+	mux.Handle("POST /admin/reset", authn.RequirePermission(auth.PermResetData, apiCfg.resetHandler))
+	mux.Handle("GET /admin/audit", authn.RequirePermission(auth.PermViewAudit, apiCfg.listAuditEventsHandler))
+	mux.Handle("PUT /admin/users/{userID}/role", authn.RequirePermission(auth.PermManageRoles, apiCfg.setUserRoleHandler))
+	mux.Handle("POST /admin/users/{userID}/impersonate", authn.RequirePermission(auth.PermImpersonate, apiCfg.impersonateHandler))
 
   mux.HandleFunc("POST /api/login", apiCfg.loginHandler)
+  mux.HandleFunc("POST /api/login/2fa", apiCfg.loginTwoFactorHandler)
+  mux.HandleFunc("POST /api/login/magic", apiCfg.magicLinkHandler)
+  mux.HandleFunc("POST /api/login/magic/verify", apiCfg.verifyMagicLinkHandler)
+  mux.HandleFunc("GET /api/login/oidc", apiCfg.oidcLoginHandler)
+  mux.HandleFunc("POST /api/login/oidc/callback", apiCfg.oidcCallbackHandler)
+  mux.HandleFunc("POST /api/login/passkey/options", apiCfg.passkeyLoginOptionsHandler)
+  mux.HandleFunc("POST /api/login/passkey", apiCfg.passkeyLoginHandler)
+
+  mux.HandleFunc("POST /api/password/forgot", apiCfg.forgotPasswordHandler)
+  mux.HandleFunc("POST /api/password/reset", apiCfg.resetPasswordHandler)
 
   mux.HandleFunc("POST /api/polka/webhooks", apiCfg.webhooksHandler)
 
@@ -521,12 +1354,41 @@ func main() {
   mux.HandleFunc("POST /api/revoke", apiCfg.revokeHandler)
 
   mux.HandleFunc("POST /api/users", apiCfg.usersHandler)
-  mux.HandleFunc("PUT /api/users", apiCfg.updateUsersHandler)
+  // an admin impersonating a user can look around, but can't change how
+  // the user logs in or delete them
+  mux.Handle("PUT /api/users", authn.RequireScope(auth.ScopeUsersWrite, authn.NotImpersonated(apiCfg.updateUsersHandler)))
+  mux.Handle("PATCH /api/users", authn.RequireScope(auth.ScopeUsersWrite, authn.NotImpersonated(apiCfg.patchUsersHandler)))
+  mux.Handle("DELETE /api/users", authn.RequireLogin(authn.NotImpersonated(apiCfg.deleteUserHandler)))
+  mux.HandleFunc("POST /api/users/verify", apiCfg.verifyEmailHandler)
+  mux.Handle("POST /api/users/verify/resend", authn.RequireScope(auth.ScopeUsersWrite, apiCfg.resendVerificationHandler))
+  mux.Handle("POST /api/users/2fa", authn.RequireLogin(authn.NotImpersonated(apiCfg.enrollTwoFactorHandler)))
+  mux.Handle("POST /api/users/2fa/confirm", authn.RequireLogin(authn.NotImpersonated(apiCfg.confirmTwoFactorHandler)))
+  mux.Handle("DELETE /api/users/2fa", authn.RequireLogin(authn.NotImpersonated(apiCfg.disableTwoFactorHandler)))
+  mux.Handle("POST /api/users/passkeys/options", authn.RequireLogin(authn.NotImpersonated(apiCfg.passkeyRegistrationOptionsHandler)))
+  mux.Handle("POST /api/users/passkeys", authn.RequireLogin(authn.NotImpersonated(apiCfg.createPasskeyHandler)))
+  mux.Handle("GET /api/users/passkeys", authn.RequireLogin(apiCfg.listPasskeysHandler))
+  mux.Handle("DELETE /api/users/passkeys/{passkeyID}", authn.RequireLogin(authn.NotImpersonated(apiCfg.deletePasskeyHandler)))
+
+  mux.Handle("GET /api/sessions", authn.RequireLogin(apiCfg.listSessionsHandler))
+  mux.Handle("DELETE /api/sessions", authn.RequireLogin(authn.NotImpersonated(apiCfg.revokeOtherSessionsHandler)))
+  mux.Handle("DELETE /api/sessions/{sessionID}", authn.RequireLogin(authn.NotImpersonated(apiCfg.revokeSessionHandler)))
+
+  mux.Handle("POST /api/tokens", authn.RequireLogin(authn.NotImpersonated(apiCfg.createAPITokenHandler)))
+  mux.Handle("GET /api/tokens", authn.RequireLogin(apiCfg.listAPITokensHandler))
+  mux.Handle("DELETE /api/tokens/{tokenID}", authn.RequireLogin(authn.NotImpersonated(apiCfg.revokeAPITokenHandler)))
+
+  mux.Handle("POST /api/oauth/clients", authn.RequireLogin(authn.NotImpersonated(apiCfg.createOAuthClientHandler)))
+  mux.Handle("GET /api/oauth/clients", authn.RequireLogin(apiCfg.listOAuthClientsHandler))
+  mux.Handle("DELETE /api/oauth/clients/{clientID}", authn.RequireLogin(authn.NotImpersonated(apiCfg.deleteOAuthClientHandler)))
+  mux.HandleFunc("GET /api/oauth/authorize", apiCfg.describeAuthorizeHandler)
+  mux.Handle("POST /api/oauth/authorize", authn.RequireLogin(authn.NotImpersonated(apiCfg.consentHandler)))
+  mux.Handle("GET /api/oauth/device", authn.RequireLogin(apiCfg.describeDeviceHandler))
+  mux.Handle("POST /api/oauth/device", authn.RequireLogin(authn.NotImpersonated(apiCfg.deviceConsentHandler)))
 
   mux.HandleFunc("GET /api/chirps", apiCfg.getAllChirpsHandler)
   mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.getChirpsByIdHandler)
-  mux.HandleFunc("POST /api/chirps", apiCfg.chirpsHandler)
-  mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.deleteChirpsByIdHandler)
+  mux.Handle("POST /api/chirps", authn.RequireScope(auth.ScopeChirpsWrite, apiCfg.chirpsHandler))
+  mux.Handle("DELETE /api/chirps/{chirpID}", authn.RequireScope(auth.ScopeChirpsWrite, apiCfg.deleteChirpsByIdHandler))
 
 	mux.HandleFunc("GET /api/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("content-type", "text/plain; charset=utf-8")
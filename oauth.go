@@ -0,0 +1,602 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/Ayannamdeo/chirpy/internal/middleware"
+	"github.com/google/uuid"
+)
+
+const oauthCodeTTL = 5 * time.Minute
+
+// OAuthClient is a registered third-party client as its developer sees
+// it. ClientSecret is only set in the response that registers it.
+type OAuthClient struct {
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+}
+
+func oauthClientFromDB(client database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ClientID:     client.ID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectUris,
+		Scopes:       client.Scopes,
+		Confidential: client.SecretHash.Valid,
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// oauthError is an error response as RFC 6749 spells it, either as JSON
+// or as query parameters on the client's redirect URI.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func respondWithOAuthError(w http.ResponseWriter, code int, err *oauthError) {
+	w.Header().Set("Cache-Control", "no-store")
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+	respondWithJSON(w, code, err)
+}
+
+// validRedirectURI only lets clients register absolute https URIs, or
+// plain http on the loopback interface for native apps.
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return false
+	}
+}
+
+func (cfg *apiConfig) createOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	reqBody := struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Confidential bool     `json:"confidential"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if reqBody.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "name is required", nil)
+		return
+	}
+	// clients without a redirect URI, like CLIs, can only use the device
+	// flow
+	if reqBody.RedirectURIs == nil {
+		reqBody.RedirectURIs = []string{}
+	}
+	for _, uri := range reqBody.RedirectURIs {
+		if !validRedirectURI(uri) {
+			respondWithError(w, http.StatusBadRequest, "invalid redirect URI "+uri, nil)
+			return
+		}
+	}
+	if len(reqBody.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "at least one scope is required", nil)
+		return
+	}
+	scopes := []string{}
+	for _, scope := range reqBody.Scopes {
+		if !auth.ValidScope(scope) {
+			respondWithError(w, http.StatusBadRequest, "unknown scope "+scope, nil)
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	idData := make([]byte, 16)
+	if _, err := rand.Read(idData); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't make client ID", err)
+		return
+	}
+	secret := ""
+	secretHash := sql.NullString{}
+	if reqBody.Confidential {
+		var err error
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't make client secret", err)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+	client, err := cfg.db.CreateOauthClient(r.Context(), database.CreateOauthClientParams{
+		ID:           hex.EncodeToString(idData),
+		UserID:       userID,
+		Name:         reqBody.Name,
+		SecretHash:   secretHash,
+		RedirectUris: reqBody.RedirectURIs,
+		Scopes:       scopes,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save OAuth client", err)
+		return
+	}
+	resp := oauthClientFromDB(client)
+	resp.ClientSecret = secret
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (cfg *apiConfig) listOAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	clients, err := cfg.db.ListOauthClientsForUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list OAuth clients", err)
+		return
+	}
+	resp := []OAuthClient{}
+	for _, client := range clients {
+		resp = append(resp, oauthClientFromDB(client))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// deleteOAuthClientHandler also ends every session users gave the client.
+func (cfg *apiConfig) deleteOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	client, err := cfg.db.GetOauthClient(r.Context(), r.PathValue("clientID"))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && client.UserID != userID) {
+		respondWithError(w, http.StatusNotFound, "OAuth client not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get OAuth client", err)
+		return
+	}
+	families, err := cfg.db.RevokeRefreshTokensForClient(r.Context(), sql.NullString{String: client.ID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke client sessions", err)
+		return
+	}
+	if err := cfg.denySessions(r.Context(), families); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access tokens", err)
+		return
+	}
+	_, err = cfg.db.DeleteOauthClient(r.Context(), database.DeleteOauthClientParams{
+		ID:     client.ID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete OAuth client", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeRequest holds the parameters of an authorization request
+// (RFC 6749 section 4.1.1 with PKCE).
+type authorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+func authorizeRequestFromQuery(query url.Values) authorizeRequest {
+	return authorizeRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+}
+
+func (req authorizeRequest) query() url.Values {
+	query := url.Values{}
+	query.Set("response_type", req.ResponseType)
+	query.Set("client_id", req.ClientID)
+	query.Set("redirect_uri", req.RedirectURI)
+	query.Set("scope", req.Scope)
+	query.Set("state", req.State)
+	query.Set("code_challenge", req.CodeChallenge)
+	query.Set("code_challenge_method", req.CodeChallengeMethod)
+	return query
+}
+
+// authorizeClient looks up the client of req and checks the redirect URI
+// is one it registered. Until both check out, errors must go to the user,
+// never to the redirect URI.
+func (cfg *apiConfig) authorizeClient(ctx context.Context, req authorizeRequest) (database.OauthClient, error) {
+	client, err := cfg.db.GetOauthClient(ctx, req.ClientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.OauthClient{}, errors.New("unknown client_id")
+	}
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+	if !slices.Contains(client.RedirectUris, req.RedirectURI) {
+		return database.OauthClient{}, errors.New("redirect_uri is not registered for this client")
+	}
+	return client, nil
+}
+
+// authorizeScopes checks the rest of req and returns the scopes it asks
+// for, all of the client's when it doesn't say.
+func authorizeScopes(client database.OauthClient, req authorizeRequest) ([]string, *oauthError) {
+	if req.ResponseType != "code" {
+		return nil, &oauthError{Code: "unsupported_response_type", Description: "only the code flow is supported"}
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return nil, &oauthError{Code: "invalid_request", Description: "PKCE with code_challenge_method=S256 is required"}
+	}
+	return clientScopes(client, req.Scope)
+}
+
+// clientScopes returns the scopes in the space-separated scope parameter,
+// all of the client's when it is empty.
+func clientScopes(client database.OauthClient, scope string) ([]string, *oauthError) {
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		return client.Scopes, nil
+	}
+	scopes := []string{}
+	for _, scope := range requested {
+		if !slices.Contains(client.Scopes, scope) {
+			return nil, &oauthError{Code: "invalid_scope", Description: "scope " + scope + " is not allowed for this client"}
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+func redirectWithParams(redirectURI string, params url.Values) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func redirectWithError(req authorizeRequest, oauthErr *oauthError) string {
+	params := url.Values{}
+	params.Set("error", oauthErr.Code)
+	params.Set("error_description", oauthErr.Description)
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	return redirectWithParams(req.RedirectURI, params)
+}
+
+// oauthAuthorizeHandler is where clients send the user. A valid request
+// goes on to the consent page, which logs the user in and asks them.
+func (cfg *apiConfig) oauthAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	req := authorizeRequestFromQuery(r.URL.Query())
+	client, err := cfg.authorizeClient(r.Context(), req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if _, oauthErr := authorizeScopes(client, req); oauthErr != nil {
+		http.Redirect(w, r, redirectWithError(req, oauthErr), http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/app/authorize.html?"+req.query().Encode(), http.StatusFound)
+}
+
+// describeAuthorizeHandler tells the consent page who is asking for what.
+func (cfg *apiConfig) describeAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	req := authorizeRequestFromQuery(r.URL.Query())
+	client, err := cfg.authorizeClient(r.Context(), req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	scopes, oauthErr := authorizeScopes(client, req)
+	if oauthErr != nil {
+		respondWithError(w, http.StatusBadRequest, oauthErr.Description, oauthErr)
+		return
+	}
+	type response struct {
+		ClientName  string   `json:"client_name"`
+		Scopes      []string `json:"scopes"`
+		RedirectURI string   `json:"redirect_uri"`
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		ClientName:  client.Name,
+		Scopes:      scopes,
+		RedirectURI: req.RedirectURI,
+	})
+}
+
+// consentHandler records the logged-in user's answer on the consent page
+// and tells the page where to send them back to the client. The session
+// the page logged in with ends with the answer.
+func (cfg *apiConfig) consentHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	reqBody := struct {
+		authorizeRequest
+		Approve bool `json:"approve"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	req := reqBody.authorizeRequest
+	client, err := cfg.authorizeClient(r.Context(), req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	type response struct {
+		RedirectTo string `json:"redirect_to"`
+	}
+	scopes, oauthErr := authorizeScopes(client, req)
+	if oauthErr == nil && !reqBody.Approve {
+		oauthErr = &oauthError{Code: "access_denied", Description: "the user denied the request"}
+	}
+	if oauthErr != nil {
+		cfg.endConsentSession(r)
+		respondWithJSON(w, http.StatusOK, response{RedirectTo: redirectWithError(req, oauthErr)})
+		return
+	}
+
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't make authorization code", err)
+		return
+	}
+	err = cfg.db.CreateOauthAuthorizationCode(r.Context(), database.CreateOauthAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      client.ID,
+		UserID:        userID,
+		RedirectUri:   req.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().UTC().Add(oauthCodeTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save authorization code", err)
+		return
+	}
+	params := url.Values{}
+	params.Set("code", code)
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	cfg.endConsentSession(r)
+	respondWithJSON(w, http.StatusOK, response{RedirectTo: redirectWithParams(req.RedirectURI, params)})
+}
+
+// authenticateOAuthClient identifies the client calling the token or
+// revocation endpoint, by HTTP Basic auth or by client_id and
+// client_secret in the form. Public clients only send their client_id.
+func (cfg *apiConfig) authenticateOAuthClient(r *http.Request) (database.OauthClient, *oauthError) {
+	invalid := &oauthError{Code: "invalid_client", Description: "client authentication failed"}
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		// RFC 6749 section 2.3.1 form-encodes both before Basic auth
+		var err error
+		if clientID, err = url.QueryUnescape(clientID); err != nil {
+			return database.OauthClient{}, invalid
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return database.OauthClient{}, invalid
+		}
+	} else {
+		clientID = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+	client, err := cfg.db.GetOauthClient(r.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, invalid
+	}
+	if !client.SecretHash.Valid {
+		if secret != "" {
+			return database.OauthClient{}, invalid
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash.String)) != 1 {
+		return database.OauthClient{}, invalid
+	}
+	return client, nil
+}
+
+func (cfg *apiConfig) oauthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, &oauthError{Code: "invalid_request", Description: "couldn't parse form"})
+		return
+	}
+	client, oauthErr := cfg.authenticateOAuthClient(r)
+	if oauthErr != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, oauthErr)
+		return
+	}
+	invalidGrant := &oauthError{Code: "invalid_grant", Description: "the grant is invalid, expired or was already used"}
+
+	var tokens sessionTokens
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		code, err := cfg.db.UseOauthAuthorizationCode(r.Context(), auth.HashToken(r.PostFormValue("code")))
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't use authorization code", err)
+			return
+		}
+		if code.ClientID != client.ID || code.RedirectUri != r.PostFormValue("redirect_uri") ||
+			!auth.VerifyPKCE(r.PostFormValue("code_verifier"), code.CodeChallenge) {
+			respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+			return
+		}
+		tokens, err = cfg.startSession(r, code.UserID, client.Name, sql.NullString{String: client.ID, Valid: true}, code.Scopes)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't start session", err)
+			return
+		}
+	case "refresh_token":
+		oldToken, err := cfg.lookupRefreshToken(r.Context(), r.PostFormValue("refresh_token"))
+		if err != nil || oldToken.ClientID.String != client.ID {
+			respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+			return
+		}
+		tokens, err = cfg.rotateRefreshToken(r, oldToken)
+		if errors.Is(err, errRefreshTokenReused) || errors.Is(err, errRefreshTokenExpired) {
+			respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refreshToken", err)
+			return
+		}
+		newToken := tokens.Session
+		cfg.audit(r, auditTokenRefreshed, newToken.UserID, newToken.UserID, auditMeta{"session_id": newToken.FamilyID, "client_id": client.ID})
+	case deviceCodeGrantType:
+		var oauthErr *oauthError
+		var err error
+		tokens, oauthErr, err = cfg.deviceCodeTokens(r, client)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check device code", err)
+			return
+		}
+		if oauthErr != nil {
+			respondWithOAuthError(w, http.StatusBadRequest, oauthErr)
+			return
+		}
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, &oauthError{Code: "unsupported_grant_type"})
+		return
+	}
+
+	type response struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, response{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(tokens.AccessTokenTTL.Seconds()),
+		RefreshToken: tokens.RefreshToken,
+		Scope:        strings.Join(tokens.Session.Scopes, " "),
+	})
+}
+
+// oauthRevokeHandler implements RFC 7009. A refresh token ends its whole
+// session, an access token only itself. Tokens the client doesn't own are
+// ignored, and the answer is always 200 so it can't be used as an oracle.
+func (cfg *apiConfig) oauthRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, &oauthError{Code: "invalid_request", Description: "couldn't parse form"})
+		return
+	}
+	client, oauthErr := cfg.authenticateOAuthClient(r)
+	if oauthErr != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, oauthErr)
+		return
+	}
+	token := r.PostFormValue("token")
+	if refreshToken, err := cfg.lookupRefreshToken(r.Context(), token); err == nil {
+		if refreshToken.ClientID.String == client.ID {
+			if err := cfg.db.RevokeRefreshTokenFamily(r.Context(), refreshToken.FamilyID); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't revoke refreshToken", err)
+				return
+			}
+			if err := cfg.denylist.DenySession(r.Context(), refreshToken.FamilyID); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access tokens", err)
+				return
+			}
+			cfg.audit(r, auditSessionRevoked, refreshToken.UserID, refreshToken.UserID, auditMeta{"session_id": refreshToken.FamilyID, "client_id": client.ID})
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if claims, err := auth.ParseJWT(token, cfg.jwtKeys, nil); err == nil && claims.ClientID == client.ID {
+		if jti, err := uuid.Parse(claims.ID); err == nil {
+			if err := cfg.denylist.DenyToken(r.Context(), jti, claims.ExpiresAt.Time); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access token", err)
+				return
+			}
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// oauthMetadataHandler serves RFC 8414 authorization server metadata.
+func (cfg *apiConfig) oauthMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	type metadata struct {
+		Issuer                            string   `json:"issuer"`
+		AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+		DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+		TokenEndpoint                     string   `json:"token_endpoint"`
+		RevocationEndpoint                string   `json:"revocation_endpoint"`
+		JWKSURI                           string   `json:"jwks_uri"`
+		ScopesSupported                   []string `json:"scopes_supported"`
+		ResponseTypesSupported            []string `json:"response_types_supported"`
+		GrantTypesSupported               []string `json:"grant_types_supported"`
+		CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+		TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, metadata{
+		Issuer:                            cfg.publicURL,
+		AuthorizationEndpoint:             cfg.publicURL + "/oauth/authorize",
+		DeviceAuthorizationEndpoint:       cfg.publicURL + "/oauth/device_authorization",
+		TokenEndpoint:                     cfg.publicURL + "/oauth/token",
+		RevocationEndpoint:                cfg.publicURL + "/oauth/revoke",
+		JWKSURI:                           cfg.publicURL + "/.well-known/jwks.json",
+		ScopesSupported:                   auth.Scopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", deviceCodeGrantType},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+	})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/Ayannamdeo/chirpy/internal/oidc"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	oidcLoginTTL    = 10 * time.Minute
+	oidcStateCookie = "chirpy_oidc_state"
+)
+
+var errOIDCEmailUnverified = errors.New("the identity provider hasn't verified this email address")
+
+// oidcLoginHandler sends the browser to the identity provider. The state
+// also goes in a cookie, so the callback only completes in the browser
+// that started the login.
+func (cfg *apiConfig) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "OIDC login is not configured", nil)
+		return
+	}
+	state, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't make state", err)
+		return
+	}
+	nonce, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't make nonce", err)
+		return
+	}
+	verifier, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't make code verifier", err)
+		return
+	}
+	if err := cfg.db.DeleteExpiredOidcLoginStates(r.Context()); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't clean up OIDC logins", err)
+		return
+	}
+	err = cfg.db.CreateOidcLoginState(r.Context(), database.CreateOidcLoginStateParams{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		DeviceLabel:  r.URL.Query().Get("device_label"),
+		ExpiresAt:    time.Now().UTC().Add(oidcLoginTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save OIDC login", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/login/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, cfg.oidc.AuthCodeURL(state, nonce, auth.PKCEChallenge(verifier)), http.StatusFound)
+}
+
+// oidcCallbackHandler is called by the callback page with what the
+// provider sent back, and answers like loginHandler.
+func (cfg *apiConfig) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "OIDC login is not configured", nil)
+		return
+	}
+	reqBody := struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || reqBody.State == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(reqBody.State)) != 1 {
+		respondWithError(w, http.StatusBadRequest, "login was started in another browser", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   oidcStateCookie,
+		Path:   "/api/login/oidc",
+		MaxAge: -1,
+	})
+	login, err := cfg.db.UseOidcLoginState(r.Context(), reqBody.State)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "login expired, please try again", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get OIDC login", err)
+		return
+	}
+
+	rawIDToken, err := cfg.oidc.Exchange(r.Context(), reqBody.Code, login.CodeVerifier)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't complete login with the identity provider", err)
+		return
+	}
+	claims, err := cfg.oidc.Verify(r.Context(), rawIDToken, login.Nonce)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid ID token", err)
+		return
+	}
+	user, err := cfg.oidcUser(r.Context(), claims)
+	if errors.Is(err, errOIDCEmailUnverified) {
+		respondWithError(w, http.StatusForbidden, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find or create user", err)
+		return
+	}
+
+	twoFactor, err := cfg.twoFactorEnabled(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up 2fa", err)
+		return
+	}
+	if twoFactor {
+		cfg.respondWithTwoFactorChallenge(w, user)
+		return
+	}
+	cfg.respondWithSession(w, r, user, login.DeviceLabel, "oidc")
+}
+
+// oidcUser finds the user an identity belongs to. The first login links it
+// to the account with the same email, creating the account if there is
+// none, but only once the provider vouches for the address. An account
+// whose email was never verified is taken over rather than just linked.
+func (cfg *apiConfig) oidcUser(ctx context.Context, claims *oidc.IDTokenClaims) (database.User, error) {
+	identity, err := cfg.db.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Issuer:  cfg.oidc.Issuer,
+		Subject: claims.Subject,
+	})
+	if err == nil {
+		return cfg.db.GetUserById(ctx, identity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return database.User{}, errOIDCEmailUnverified
+	}
+	user, err := cfg.db.GetUserByEmail(ctx, claims.Email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// no password: the account can only log in through the provider,
+		// until the user sets one with a password reset
+		user, err = cfg.db.CreateUser(ctx, database.CreateUserParams{
+			Email:          claims.Email,
+			HashedPassword: "",
+		})
+		if isUniqueViolation(err) {
+			// another first login for the address created it meanwhile
+			user, err = cfg.db.GetUserByEmail(ctx, claims.Email)
+		}
+		if err != nil {
+			return database.User{}, err
+		}
+	case err != nil:
+		return database.User{}, err
+	case !user.EmailVerifiedAt.Valid:
+		if err := cfg.takeOverAccount(ctx, user.ID); err != nil {
+			return database.User{}, err
+		}
+	}
+	if _, err := cfg.db.VerifyUserEmail(ctx, database.VerifyUserEmailParams{
+		ID:    user.ID,
+		Email: user.Email,
+	}); err != nil {
+		return database.User{}, err
+	}
+	err = cfg.db.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Issuer:  cfg.oidc.Issuer,
+		Subject: claims.Subject,
+		UserID:  user.ID,
+	})
+	if isUniqueViolation(err) {
+		// another first login with the same identity linked it first
+		identity, err := cfg.db.GetUserIdentity(ctx, database.GetUserIdentityParams{
+			Issuer:  cfg.oidc.Issuer,
+			Subject: claims.Subject,
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+		return cfg.db.GetUserById(ctx, identity.UserID)
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	return cfg.db.GetUserById(ctx, user.ID)
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// takeOverAccount removes every way into an account that whoever signed
+// up with the address could have set up: they never proved it was theirs,
+// and the provider just said it belongs to someone else. Without this the
+// owner would log in through SSO while the squatter's password kept
+// working.
+func (cfg *apiConfig) takeOverAccount(ctx context.Context, userID uuid.UUID) error {
+	if err := cfg.db.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{
+		HashedPassword: "",
+		ID:             userID,
+	}); err != nil {
+		return err
+	}
+	if err := cfg.revokeAllSessions(ctx, userID); err != nil {
+		return err
+	}
+	if err := cfg.db.RevokeAllApiTokensForUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := cfg.db.DeleteWebauthnCredentialsForUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := cfg.db.DeleteTotpSecret(ctx, userID); err != nil {
+		return err
+	}
+	return cfg.db.DeleteRecoveryCodes(ctx, userID)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/Ayannamdeo/chirpy/internal/denylist"
+	"github.com/Ayannamdeo/chirpy/internal/oidc"
+	"github.com/Ayannamdeo/chirpy/internal/oidc/oidctest"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const testRedirectURL = "http://localhost:8080/app/oidc-callback.html"
+
+func newTestProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	t.Helper()
+	idp, err := oidctest.NewServer("chirpy", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(idp.Close)
+	p, err := oidc.Discover(context.Background(), idp.Issuer, idp.ClientID, idp.ClientSecret, testRedirectURL)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	return idp, p
+}
+
+// authorize follows AuthCodeURL to the provider and returns the code it
+// redirects back with.
+func authorize(t *testing.T, p *oidc.Provider, state, nonce, verifier string) string {
+	t.Helper()
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(p.AuthCodeURL(state, nonce, auth.PKCEChallenge(verifier)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("code")
+}
+
+// queryName matches expectations written as sqlc query names, so the
+// tests say which queries run without spelling out their SQL.
+var queryName = sqlmock.QueryMatcherFunc(func(name, actual string) error {
+	if !strings.Contains(actual, "-- name: "+name+" ") {
+		first, _, _ := strings.Cut(actual, "\n")
+		return fmt.Errorf("ran %q, want %s", first, name)
+	}
+	return nil
+})
+
+// newCallbackTest makes an apiConfig for the OIDC callback with a mock
+// database that expects the queries in the order they are set up.
+func newCallbackTest(t *testing.T) (*apiConfig, sqlmock.Sqlmock, *oidctest.Server) {
+	t.Helper()
+	idp, p := newTestProvider(t)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(queryName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sessions, err := loadSessionPolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := auth.NewKeySet()
+	keys.AddHMAC([]byte("secret"))
+	queries := database.New(db)
+	cfg := &apiConfig{
+		db:              queries,
+		jwtKeys:         keys,
+		refreshTokenKey: []byte("refresh token key"),
+		denylist:        denylist.New(queries, time.Hour),
+		sessions:        sessions,
+		oidc:            p,
+	}
+	return cfg, mock, idp
+}
+
+var userColumns = []string{"id", "created_at", "updated_at", "email", "hashed_password", "is_chirpy_red", "email_verified_at", "role", "delete_after"}
+
+func userRows(user database.User) *sqlmock.Rows {
+	var verifiedAt driver.Value
+	if user.EmailVerifiedAt.Valid {
+		verifiedAt = user.EmailVerifiedAt.Time
+	}
+	return sqlmock.NewRows(userColumns).AddRow(user.ID, user.CreatedAt, user.UpdatedAt, user.Email,
+		user.HashedPassword, user.IsChirpyRed, verifiedAt, user.Role, nil)
+}
+
+func testUser(email, password string, verified bool) database.User {
+	now := time.Now().UTC()
+	user := database.User{
+		ID:             uuid.New(),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Email:          email,
+		HashedPassword: password,
+		Role:           "user",
+	}
+	if verified {
+		user.EmailVerifiedAt.Time = now
+		user.EmailVerifiedAt.Valid = true
+	}
+	return user
+}
+
+func expectLoginState(mock sqlmock.Sqlmock) {
+	now := time.Now().UTC()
+	mock.ExpectQuery("UseOidcLoginState").WithArgs("state").WillReturnRows(
+		sqlmock.NewRows([]string{"state", "nonce", "code_verifier", "device_label", "created_at", "expires_at"}).
+			AddRow("state", "nonce", "verifier", "", now, now.Add(oidcLoginTTL)))
+}
+
+func expectNoIdentity(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("GetUserIdentity").WillReturnRows(
+		sqlmock.NewRows([]string{"issuer", "subject", "user_id", "created_at"}))
+}
+
+// expectLink is the identity being linked to user, whose email the
+// provider has just vouched for.
+func expectLink(mock sqlmock.Sqlmock, user database.User) {
+	mock.ExpectExec("VerifyUserEmail").WithArgs(user.ID, user.Email).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("CreateUserIdentity").WithArgs(sqlmock.AnyArg(), "oidctest-user", user.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	user.EmailVerifiedAt.Time = time.Now().UTC()
+	user.EmailVerifiedAt.Valid = true
+	mock.ExpectQuery("GetUserById").WithArgs(user.ID).WillReturnRows(userRows(user))
+}
+
+// expectSession is user logging in without 2FA.
+func expectSession(mock sqlmock.Sqlmock, user database.User) {
+	mock.ExpectQuery("GetTotpSecret").WithArgs(user.ID).WillReturnRows(
+		sqlmock.NewRows([]string{"user_id", "secret", "confirmed_at", "last_used_step", "created_at"}))
+	mock.ExpectQuery("GetUserById").WithArgs(user.ID).WillReturnRows(userRows(user))
+	now := time.Now().UTC()
+	mock.ExpectQuery("CreateRefreshToken").WillReturnRows(sqlmock.NewRows([]string{
+		"token_hash", "created_at", "updated_at", "user_id", "expires_at", "revoked_at", "family_id",
+		"parent_token_hash", "rotated_at", "session_started_at", "user_agent", "ip_address",
+		"device_label", "client_id", "scopes", "hashed",
+	}).AddRow("hash", now, now, user.ID, now.Add(time.Hour), nil, uuid.New(),
+		nil, nil, now, "", "", "", nil, nil, true))
+	mock.ExpectExec("CreateAuditEvent").WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func callback(t *testing.T, cfg *apiConfig, code string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/login/oidc/callback",
+		strings.NewReader(`{"code": "`+code+`", "state": "state"}`))
+	req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: "state"})
+	w := httptest.NewRecorder()
+	cfg.oidcCallbackHandler(w, req)
+	return w
+}
+
+// loggedIn checks the callback answered with a session for user.
+func loggedIn(t *testing.T, w *httptest.ResponseRecorder, user database.User) {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var resp User
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != user.ID || resp.Token == "" || resp.RefreshToken == "" {
+		t.Errorf("logged in as %s, want %s with tokens", resp.ID, user.ID)
+	}
+}
+
+func TestOIDCCallbackKnownIdentity(t *testing.T) {
+	cfg, mock, _ := newCallbackTest(t)
+	user := testUser("sso-user@example.com", "", true)
+	code := authorize(t, cfg.oidc, "state", "nonce", "verifier")
+	expectLoginState(mock)
+	mock.ExpectQuery("GetUserIdentity").WithArgs(cfg.oidc.Issuer, "oidctest-user").WillReturnRows(
+		sqlmock.NewRows([]string{"issuer", "subject", "user_id", "created_at"}).
+			AddRow(cfg.oidc.Issuer, "oidctest-user", user.ID, time.Now()))
+	mock.ExpectQuery("GetUserById").WithArgs(user.ID).WillReturnRows(userRows(user))
+	expectSession(mock, user)
+
+	loggedIn(t, callback(t, cfg, code), user)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOIDCCallbackCreatesAccount(t *testing.T) {
+	cfg, mock, _ := newCallbackTest(t)
+	user := testUser("sso-user@example.com", "", false)
+	code := authorize(t, cfg.oidc, "state", "nonce", "verifier")
+	expectLoginState(mock)
+	expectNoIdentity(mock)
+	mock.ExpectQuery("GetUserByEmail").WithArgs(user.Email).WillReturnRows(sqlmock.NewRows(userColumns))
+	mock.ExpectQuery("CreateUser").WithArgs(user.Email, "").WillReturnRows(userRows(user))
+	expectLink(mock, user)
+	expectSession(mock, user)
+
+	loggedIn(t, callback(t, cfg, code), user)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOIDCCallbackLinksVerifiedAccount(t *testing.T) {
+	cfg, mock, _ := newCallbackTest(t)
+	// the password keeps working: the owner proved the address was theirs
+	user := testUser("sso-user@example.com", "hash", true)
+	code := authorize(t, cfg.oidc, "state", "nonce", "verifier")
+	expectLoginState(mock)
+	expectNoIdentity(mock)
+	mock.ExpectQuery("GetUserByEmail").WithArgs(user.Email).WillReturnRows(userRows(user))
+	expectLink(mock, user)
+	expectSession(mock, user)
+
+	loggedIn(t, callback(t, cfg, code), user)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOIDCCallbackTakesOverUnverifiedAccount(t *testing.T) {
+	cfg, mock, _ := newCallbackTest(t)
+	user := testUser("sso-user@example.com", "squatter's hash", false)
+	family := uuid.New()
+	code := authorize(t, cfg.oidc, "state", "nonce", "verifier")
+	expectLoginState(mock)
+	expectNoIdentity(mock)
+	mock.ExpectQuery("GetUserByEmail").WithArgs(user.Email).WillReturnRows(userRows(user))
+	mock.ExpectExec("UpdateUserPassword").WithArgs("", user.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("RevokeAllRefreshTokensForUser").WithArgs(user.ID).WillReturnRows(
+		sqlmock.NewRows([]string{"family_id"}).AddRow(family).AddRow(family))
+	mock.ExpectExec("DenyAccessTokens").WithArgs("sid", family, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RevokeAllApiTokensForUser").WithArgs(user.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DeleteWebauthnCredentialsForUser").WithArgs(user.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DeleteTotpSecret").WithArgs(user.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DeleteRecoveryCodes").WithArgs(user.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	user.HashedPassword = ""
+	expectLink(mock, user)
+	expectSession(mock, user)
+
+	loggedIn(t, callback(t, cfg, code), user)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if !cfg.denylist.IsDenied("", family.String()) {
+		t.Error("the squatter's session wasn't denied")
+	}
+}
+
+// Two first logins for the same identity both miss it, and the second to
+// link it gets the first one's link.
+func TestOIDCCallbackConcurrentFirstLogin(t *testing.T) {
+	cfg, mock, _ := newCallbackTest(t)
+	user := testUser("sso-user@example.com", "", true)
+	code := authorize(t, cfg.oidc, "state", "nonce", "verifier")
+	expectLoginState(mock)
+	expectNoIdentity(mock)
+	mock.ExpectQuery("GetUserByEmail").WithArgs(user.Email).WillReturnRows(userRows(user))
+	mock.ExpectExec("VerifyUserEmail").WithArgs(user.ID, user.Email).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CreateUserIdentity").WillReturnError(&pq.Error{Code: "23505"})
+	mock.ExpectQuery("GetUserIdentity").WithArgs(cfg.oidc.Issuer, "oidctest-user").WillReturnRows(
+		sqlmock.NewRows([]string{"issuer", "subject", "user_id", "created_at"}).
+			AddRow(cfg.oidc.Issuer, "oidctest-user", user.ID, time.Now()))
+	mock.ExpectQuery("GetUserById").WithArgs(user.ID).WillReturnRows(userRows(user))
+	expectSession(mock, user)
+
+	loggedIn(t, callback(t, cfg, code), user)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOIDCCallbackUnverifiedEmail(t *testing.T) {
+	cfg, mock, idp := newCallbackTest(t)
+	idp.SetUser(oidctest.User{Subject: "oidctest-user", Email: "sso-user@example.com"})
+	code := authorize(t, cfg.oidc, "state", "nonce", "verifier")
+	expectLoginState(mock)
+	expectNoIdentity(mock)
+
+	w := callback(t, cfg, code)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// The state mismatch is caught before the database is needed, so the
+// handler can run without one.
+func TestOIDCCallbackStateMismatch(t *testing.T) {
+	_, p := newTestProvider(t)
+	cfg := &apiConfig{oidc: p}
+	tests := []struct {
+		name   string
+		cookie string
+		state  string
+	}{
+		{"different state", "state", "another state"},
+		{"no cookie", "", "state"},
+		{"no state", "state", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/login/oidc/callback",
+				strings.NewReader(`{"code": "code", "state": "`+tt.state+`"}`))
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			cfg.oidcCallbackHandler(w, req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("got %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
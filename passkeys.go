@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/Ayannamdeo/chirpy/internal/middleware"
+	"github.com/Ayannamdeo/chirpy/internal/webauthn"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Purposes of webauthn_challenges.
+const (
+	passkeyRegistration = "registration"
+	passkeyLogin        = "login"
+)
+
+var errUnknownChallenge = errors.New("unknown or expired challenge")
+
+// recentLoginAge is how long after logging in a user without a password,
+// like one who signed up through SSO, may add a passkey.
+const recentLoginAge = 10 * time.Minute
+
+// Passkey is a registered WebAuthn credential as its owner sees it.
+type Passkey struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func passkeyFromDB(cred database.WebauthnCredential) Passkey {
+	resp := Passkey{
+		ID:        cred.ID,
+		Name:      cred.Name,
+		CreatedAt: cred.CreatedAt,
+	}
+	if cred.LastUsedAt.Valid {
+		resp.LastUsedAt = &cred.LastUsedAt.Time
+	}
+	return resp
+}
+
+// newWebauthnChallenge starts a ceremony. Registrations are tied to the
+// user asking, logins to nobody until the answer says who it is.
+func (cfg *apiConfig) newWebauthnChallenge(ctx context.Context, purpose string, userID uuid.NullUUID) (webauthn.Base64URL, error) {
+	if err := cfg.db.DeleteExpiredWebauthnChallenges(ctx); err != nil {
+		log.Printf("Error cleaning up passkey challenges: %v", err)
+	}
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.db.CreateWebauthnChallenge(ctx, database.CreateWebauthnChallengeParams{
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Purpose:   purpose,
+		UserID:    userID,
+		ExpiresAt: time.Now().UTC().Add(webauthn.Timeout),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// useWebauthnChallenge finds the challenge clientDataJSON answers and
+// makes sure it can't be answered again. The challenge is still checked
+// by the webauthn package, along with the rest of clientDataJSON.
+func (cfg *apiConfig) useWebauthnChallenge(ctx context.Context, clientDataJSON []byte, purpose string) (database.WebauthnChallenge, []byte, error) {
+	clientData, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return database.WebauthnChallenge{}, nil, errUnknownChallenge
+	}
+	challenge, err := cfg.db.UseWebauthnChallenge(ctx, database.UseWebauthnChallengeParams{
+		Challenge: clientData.Challenge,
+		Purpose:   purpose,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.WebauthnChallenge{}, nil, errUnknownChallenge
+	}
+	if err != nil {
+		return database.WebauthnChallenge{}, nil, err
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(challenge.Challenge)
+	if err != nil {
+		return database.WebauthnChallenge{}, nil, err
+	}
+	return challenge, raw, nil
+}
+
+// passkeyRegistrationOptionsHandler answers with the options for
+// navigator.credentials.create(). The user handle is the user ID, so a
+// passkey login says whose passkey it is. A passkey is a way into the
+// account like the password, so adding one takes the current password:
+// otherwise a stolen access token could leave a passkey behind. Accounts
+// without a password need a session that has just logged in instead.
+func (cfg *apiConfig) passkeyRegistrationOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.FromContext(r.Context())
+	userID := principal.UserID
+	reqBody := struct {
+		CurrentPassword string `json:"current_password"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	user, err := cfg.db.GetUserById(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.HashedPassword == "" {
+		if !cfg.checkRecentLogin(w, r, principal) {
+			return
+		}
+	} else if !cfg.checkCurrentPassword(w, r, user, reqBody.CurrentPassword) {
+		return
+	}
+	creds, err := cfg.db.ListWebauthnCredentialsForUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list passkeys", err)
+		return
+	}
+	exclude := []webauthn.CredentialDescriptor{}
+	for _, cred := range creds {
+		exclude = append(exclude, webauthn.CredentialDescriptor{
+			Type:       "public-key",
+			ID:         cred.CredentialID,
+			Transports: cred.Transports,
+		})
+	}
+	challenge, err := cfg.newWebauthnChallenge(r.Context(), passkeyRegistration, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't make challenge", err)
+		return
+	}
+	options := cfg.webauthn.CreationOptions(challenge, webauthn.UserEntity{
+		ID:          userID[:],
+		Name:        user.Email,
+		DisplayName: user.Email,
+	}, exclude)
+	respondWithJSON(w, http.StatusOK, struct {
+		PublicKey webauthn.CreationOptions `json:"publicKey"`
+	}{options})
+}
+
+// checkRecentLogin answers 401 unless principal's session started less
+// than recentLoginAge ago.
+func (cfg *apiConfig) checkRecentLogin(w http.ResponseWriter, r *http.Request, principal *middleware.Principal) bool {
+	sessions, err := cfg.db.ListSessionsForUser(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list sessions", err)
+		return false
+	}
+	for _, session := range sessions {
+		if principal.SessionID != "" && session.FamilyID.String() == principal.SessionID &&
+			time.Since(session.SessionStartedAt) < recentLoginAge {
+			return true
+		}
+	}
+	respondWithError(w, http.StatusUnauthorized, "log in again to add a passkey", nil)
+	return false
+}
+
+// createPasskeyHandler stores the credential the browser created with
+// the registration options.
+func (cfg *apiConfig) createPasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	reqBody := struct {
+		Name       string                        `json:"name"`
+		Credential webauthn.RegistrationResponse `json:"credential"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if reqBody.Name == "" {
+		reqBody.Name = "Passkey"
+	}
+	challenge, rawChallenge, err := cfg.useWebauthnChallenge(r.Context(), reqBody.Credential.Response.ClientDataJSON, passkeyRegistration)
+	if errors.Is(err, errUnknownChallenge) || (err == nil && challenge.UserID.UUID != userID) {
+		respondWithError(w, http.StatusBadRequest, "unknown or expired challenge", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get challenge", err)
+		return
+	}
+	cred, err := cfg.webauthn.VerifyRegistration(reqBody.Credential, rawChallenge)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid passkey", err)
+		return
+	}
+	transports := cred.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+	created, err := cfg.db.CreateWebauthnCredential(r.Context(), database.CreateWebauthnCredentialParams{
+		UserID:       userID,
+		CredentialID: cred.ID,
+		PublicKey:    cred.PublicKey,
+		SignCount:    int64(cred.SignCount),
+		Transports:   transports,
+		Name:         reqBody.Name,
+	})
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		respondWithError(w, http.StatusConflict, "passkey is already registered", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save passkey", err)
+		return
+	}
+	cfg.audit(r, auditPasskeyAdded, userID, userID, auditMeta{"passkey_id": created.ID, "name": created.Name})
+	respondWithJSON(w, http.StatusCreated, passkeyFromDB(created))
+}
+
+func (cfg *apiConfig) listPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	creds, err := cfg.db.ListWebauthnCredentialsForUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list passkeys", err)
+		return
+	}
+	resp := []Passkey{}
+	for _, cred := range creds {
+		resp = append(resp, passkeyFromDB(cred))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) deletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	passkeyID, err := uuid.Parse(r.PathValue("passkeyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID format", err)
+		return
+	}
+	deleted, err := cfg.db.DeleteWebauthnCredential(r.Context(), database.DeleteWebauthnCredentialParams{
+		ID:     passkeyID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove passkey", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "passkey not found", nil)
+		return
+	}
+	cfg.audit(r, auditPasskeyRemoved, userID, userID, auditMeta{"passkey_id": passkeyID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// passkeyLoginOptionsHandler answers with the options for
+// navigator.credentials.get(). No email is asked for: the browser offers
+// the passkeys it has for this site. Anyone can ask, and each answer is a
+// stored challenge, so every one counts against the client IP as a failed
+// login would.
+func (cfg *apiConfig) passkeyLoginOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	ip := cfg.clientIP(r)
+	wait, err := cfg.ipThrottle.Check(r.Context(), ip)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check failed logins", err)
+		return
+	}
+	if wait > 0 {
+		respondWithRetryAfter(w, wait, "too many login attempts, try again later")
+		return
+	}
+	if _, err := cfg.ipThrottle.Fail(r.Context(), ip); err != nil {
+		log.Printf("Error counting passkey challenge for %s: %v", ip, err)
+	}
+
+	challenge, err := cfg.newWebauthnChallenge(r.Context(), passkeyLogin, uuid.NullUUID{})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't make challenge", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, struct {
+		PublicKey webauthn.RequestOptions `json:"publicKey"`
+	}{cfg.webauthn.RequestOptions(challenge)})
+}
+
+// passkeyLoginHandler logs in with a passkey, answering like loginHandler.
+// There is no account to count failures against until the passkey is
+// verified, so only the client IP is throttled.
+func (cfg *apiConfig) passkeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody := struct {
+		Credential  webauthn.AssertionResponse `json:"credential"`
+		DeviceLabel string                     `json:"device_label"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	ip := cfg.clientIP(r)
+	wait, err := cfg.ipThrottle.Check(r.Context(), ip)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check failed logins", err)
+		return
+	}
+	if wait > 0 {
+		cfg.audit(r, auditLoginFailed, uuid.Nil, uuid.Nil, auditMeta{"method": "passkey", "reason": "throttled"})
+		respondWithRetryAfter(w, wait, "too many failed logins, try again later")
+		return
+	}
+	fail := func(userID uuid.UUID, reason string, err error) {
+		if _, err := cfg.ipThrottle.Fail(r.Context(), ip); err != nil {
+			log.Printf("Error recording failed login from %s: %v", ip, err)
+		}
+		cfg.audit(r, auditLoginFailed, uuid.Nil, userID, auditMeta{"method": "passkey", "reason": reason})
+		respondWithError(w, http.StatusUnauthorized, "passkey login failed", err)
+	}
+
+	_, rawChallenge, err := cfg.useWebauthnChallenge(r.Context(), reqBody.Credential.Response.ClientDataJSON, passkeyLogin)
+	if errors.Is(err, errUnknownChallenge) {
+		fail(uuid.Nil, "unknown challenge", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get challenge", err)
+		return
+	}
+	cred, err := cfg.db.GetWebauthnCredentialByCredentialID(r.Context(), reqBody.Credential.RawID)
+	if errors.Is(err, sql.ErrNoRows) {
+		fail(uuid.Nil, "unknown passkey", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get passkey", err)
+		return
+	}
+	// the user handle is the user ID we registered the passkey with
+	if string(reqBody.Credential.Response.UserHandle) != string(cred.UserID[:]) {
+		fail(cred.UserID, "passkey user mismatch", nil)
+		return
+	}
+	assertion, err := cfg.webauthn.VerifyAssertion(reqBody.Credential, rawChallenge, cred.PublicKey, uint32(cred.SignCount))
+	if errors.Is(err, webauthn.ErrSignCount) {
+		fail(cred.UserID, "passkey signature counter went backwards", err)
+		return
+	}
+	if err != nil {
+		fail(cred.UserID, "invalid passkey signature", err)
+		return
+	}
+	err = cfg.db.UpdateWebauthnCredentialSignCount(r.Context(), database.UpdateWebauthnCredentialSignCountParams{
+		ID:        cred.ID,
+		SignCount: int64(assertion.SignCount),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update passkey", err)
+		return
+	}
+	user, err := cfg.db.GetUserById(r.Context(), cred.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	// A passkey that checked a PIN or fingerprint is already two factors.
+	// One that only checked someone touched it is like a password.
+	if !assertion.UserVerified {
+		twoFactor, err := cfg.twoFactorEnabled(r.Context(), user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't look up 2fa", err)
+			return
+		}
+		if twoFactor {
+			cfg.respondWithTwoFactorChallenge(w, user)
+			return
+		}
+	}
+	cfg.respondWithSession(w, r, user, reqBody.DeviceLabel, "passkey")
+}
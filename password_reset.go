@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/Ayannamdeo/chirpy/internal/mailer"
+)
+
+const passwordResetTTL = time.Hour
+
+// forgotPasswordHandler always answers 202, whether or not the email
+// belongs to an account, so it can't be used to probe for users. The
+// lookup and the mail happen after answering, so the response time
+// doesn't tell either. Every request counts against the address, so it
+// can't be used to flood someone's inbox.
+func (cfg *apiConfig) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody := struct {
+		Email string `json:"email"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	emailKey := strings.ToLower(strings.TrimSpace(reqBody.Email))
+	if emailKey == "" {
+		respondWithError(w, http.StatusBadRequest, "email is required", nil)
+		return
+	}
+	wait, err := cfg.passwordResetThrottle.Check(r.Context(), emailKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check sent reset links", err)
+		return
+	}
+	if wait > 0 {
+		respondWithRetryAfter(w, wait, "too many password resets requested, try again later")
+		return
+	}
+	if _, err := cfg.passwordResetThrottle.Fail(r.Context(), emailKey); err != nil {
+		log.Printf("Error counting password reset for %s: %v", emailKey, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Minute)
+	go func() {
+		defer cancel()
+		cfg.sendPasswordReset(ctx, reqBody.Email)
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendPasswordReset mails a reset link if email belongs to an account.
+// Nobody is waiting for it, so errors are only logged.
+func (cfg *apiConfig) sendPasswordReset(ctx context.Context, email string) {
+	user, err := cfg.db.GetUserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error fetching user for password reset: %v", err)
+		}
+		return
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Error making reset token for user %s: %v", user.ID, err)
+		return
+	}
+	err = cfg.db.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(passwordResetTTL),
+	})
+	if err != nil {
+		log.Printf("Error saving reset token for user %s: %v", user.ID, err)
+		return
+	}
+	link := cfg.publicURL + "/app/reset-password.html?token=" + url.QueryEscape(token)
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Chirpy account.\n\n"+
+			"Open this link within an hour to choose a new password:\n\n%s\n\n"+
+			"If it wasn't you, you can ignore this email. Your password stays the same.\n", link),
+	})
+	if err != nil {
+		log.Printf("Error sending password reset email to user %s: %v", user.ID, err)
+	}
+}
+
+func (cfg *apiConfig) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody := struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if reqBody.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "password is required", nil)
+		return
+	}
+	userID, err := cfg.db.UsePasswordResetToken(r.Context(), auth.HashToken(reqBody.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "invalid or expired reset token", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't use reset token", err)
+		return
+	}
+
+	hashedPass, err := auth.HashPassword(reqBody.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "couldn't hash password", err)
+		return
+	}
+	err = cfg.db.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		HashedPassword: hashedPass,
+		ID:             userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+	if err := cfg.db.InvalidatePasswordResetTokens(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't invalidate reset tokens", err)
+		return
+	}
+	if err := cfg.revokeAllSessions(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	// API tokens and passkeys are logins too, and whoever took the account
+	// may have added some
+	if err := cfg.db.RevokeAllApiTokensForUser(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API tokens", err)
+		return
+	}
+	if err := cfg.db.DeleteWebauthnCredentialsForUser(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove passkeys", err)
+		return
+	}
+	cfg.audit(r, auditPasswordReset, userID, userID, nil)
+	// The owner is back in control, let them log in right away.
+	user, err := cfg.db.GetUserById(r.Context(), userID)
+	if err == nil {
+		emailKey := strings.ToLower(strings.TrimSpace(user.Email))
+		if err := cfg.emailThrottle.Reset(r.Context(), emailKey); err != nil {
+			log.Printf("Error resetting failed logins for user %s: %v", userID, err)
+		}
+		if err := cfg.passwordResetThrottle.Reset(r.Context(), emailKey); err != nil {
+			log.Printf("Error resetting sent reset links for user %s: %v", userID, err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/database"
+)
+
+// legacyRefreshTokenBatch is how many tokens from before hashing the
+// startup sweep hashes per query.
+const legacyRefreshTokenBatch = 500
+
+// lookupRefreshToken finds the stored refresh token for a raw one. Tokens
+// issued before they were stored hashed are hashed on the spot, so the
+// session goes on as if nothing happened.
+func (cfg *apiConfig) lookupRefreshToken(ctx context.Context, refreshToken string) (database.RefreshToken, error) {
+	tokenHash := auth.HashRefreshToken(refreshToken, cfg.refreshTokenKey)
+	token, err := cfg.db.GetRefreshToken(ctx, tokenHash)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return token, err
+	}
+	hashed, err := cfg.db.HashLegacyRefreshToken(ctx, database.HashLegacyRefreshTokenParams{
+		TokenHash: tokenHash,
+		Token:     refreshToken,
+	})
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	if hashed == 0 {
+		return database.RefreshToken{}, sql.ErrNoRows
+	}
+	return cfg.db.GetRefreshToken(ctx, tokenHash)
+}
+
+// hashLegacyRefreshTokens hashes every refresh token still stored raw, so
+// they don't sit in the database until their owners next refresh.
+func (cfg *apiConfig) hashLegacyRefreshTokens(ctx context.Context) {
+	total := 0
+	for {
+		tokens, err := cfg.db.ListLegacyRefreshTokens(ctx, legacyRefreshTokenBatch)
+		if err != nil {
+			log.Printf("Error listing unhashed refresh tokens: %s", err)
+			return
+		}
+		if len(tokens) == 0 {
+			break
+		}
+		for _, token := range tokens {
+			_, err := cfg.db.HashLegacyRefreshToken(ctx, database.HashLegacyRefreshTokenParams{
+				TokenHash: auth.HashRefreshToken(token, cfg.refreshTokenKey),
+				Token:     token,
+			})
+			if err != nil {
+				log.Printf("Error hashing refresh token: %s", err)
+				return
+			}
+		}
+		total += len(tokens)
+	}
+	if total > 0 {
+		log.Printf("Hashed %d refresh tokens stored before hashing", total)
+	}
+}
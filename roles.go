@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/Ayannamdeo/chirpy/internal/middleware"
+	"github.com/google/uuid"
+)
+
+// makeAdmin promotes the account with email to admin. It is how the first
+// admin comes to be, from -make-admin or ADMIN_EMAIL. Only a verified
+// account is promoted: anyone can sign up with an address that hasn't
+// been taken yet.
+func makeAdmin(ctx context.Context, db *database.Queries, email string) (database.User, error) {
+	return db.SetUserRoleByEmail(ctx, database.SetUserRoleByEmailParams{
+		Role:  auth.RoleAdmin,
+		Email: email,
+	})
+}
+
+func (cfg *apiConfig) setUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.FromContext(r.Context())
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID format", err)
+		return
+	}
+	reqBody := struct {
+		Role string `json:"role"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !auth.ValidRole(reqBody.Role) {
+		respondWithError(w, http.StatusBadRequest, "unknown role "+reqBody.Role, nil)
+		return
+	}
+	// so the last admin can't lock everyone out by accident
+	if userID == principal.UserID {
+		respondWithError(w, http.StatusBadRequest, "you can't change your own role", nil)
+		return
+	}
+	oldUser, err := cfg.db.GetUserById(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "user not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	user, err := cfg.db.SetUserRole(r.Context(), database.SetUserRoleParams{
+		Role: reqBody.Role,
+		ID:   userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set role", err)
+		return
+	}
+	cfg.audit(r, auditRoleChanged, principal.UserID, userID, auditMeta{"from": oldUser.Role, "to": user.Role})
+	// Access tokens carry the permissions of the old role until they
+	// expire; when some were taken away, end the sessions right away.
+	newPermissions := auth.Permissions(user.Role)
+	for _, permission := range auth.Permissions(oldUser.Role) {
+		if !slices.Contains(newPermissions, permission) {
+			if err := cfg.revokeAllSessions(r.Context(), userID); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+				return
+			}
+			break
+		}
+	}
+	type response struct {
+		ID   uuid.UUID `json:"id"`
+		Role string    `json:"role"`
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		ID:   user.ID,
+		Role: user.Role,
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+)
+
+// sessionPolicy says how long the tokens of a session live.
+type sessionPolicy struct {
+	AccessTokenTTL time.Duration
+	// IdleTimeout ends a session whose refresh token goes unused for that
+	// long. Every refresh starts it over. Zero turns it off.
+	IdleTimeout time.Duration
+	// MaxAge ends a session that long after login, however active it is.
+	MaxAge time.Duration
+}
+
+var defaultSessionPolicy = sessionPolicy{
+	AccessTokenTTL: time.Hour,
+	MaxAge:         60 * 24 * time.Hour,
+}
+
+// refreshExpiry is when a refresh token issued at now for a session that
+// started at startedAt expires.
+func (p sessionPolicy) refreshExpiry(startedAt, now time.Time) time.Time {
+	expiry := startedAt.Add(p.MaxAge)
+	if p.IdleTimeout > 0 && now.Add(p.IdleTimeout).Before(expiry) {
+		expiry = now.Add(p.IdleTimeout)
+	}
+	return expiry
+}
+
+// sessionPolicies are the default policy and overrides for some roles,
+// typically shorter sessions for admins.
+type sessionPolicies struct {
+	defaults sessionPolicy
+	roles    map[string]sessionPolicy
+}
+
+func (p sessionPolicies) forRole(role string) sessionPolicy {
+	if policy, ok := p.roles[role]; ok {
+		return policy
+	}
+	return p.defaults
+}
+
+// maxAccessTokenTTL is the longest any access token can live.
+func (p sessionPolicies) maxAccessTokenTTL() time.Duration {
+	ttl := p.defaults.AccessTokenTTL
+	for _, policy := range p.roles {
+		ttl = max(ttl, policy.AccessTokenTTL)
+	}
+	return ttl
+}
+
+// loadSessionPolicies reads ACCESS_TOKEN_TTL, SESSION_IDLE_TIMEOUT and
+// SESSION_MAX_AGE for everyone, then SESSION_POLICY_<ROLE> overrides like
+// SESSION_POLICY_ADMIN="access_token_ttl=10m,idle_timeout=1h,max_age=12h".
+// Settings a role doesn't override come from the defaults.
+func loadSessionPolicies() (sessionPolicies, error) {
+	policies := sessionPolicies{
+		defaults: defaultSessionPolicy,
+		roles:    map[string]sessionPolicy{},
+	}
+	for env, dst := range map[string]*time.Duration{
+		"ACCESS_TOKEN_TTL":     &policies.defaults.AccessTokenTTL,
+		"SESSION_IDLE_TIMEOUT": &policies.defaults.IdleTimeout,
+		"SESSION_MAX_AGE":      &policies.defaults.MaxAge,
+	} {
+		v := os.Getenv(env)
+		if v == "" {
+			continue
+		}
+		d, err := parseDuration(v)
+		if err != nil {
+			return sessionPolicies{}, fmt.Errorf("%s: %w", env, err)
+		}
+		*dst = d
+	}
+	if err := policies.defaults.validate(); err != nil {
+		return sessionPolicies{}, err
+	}
+	for _, role := range []string{auth.RoleUser, auth.RoleModerator, auth.RoleAdmin} {
+		env := "SESSION_POLICY_" + strings.ToUpper(role)
+		spec := os.Getenv(env)
+		if spec == "" {
+			continue
+		}
+		policy, err := parseSessionPolicy(policies.defaults, spec)
+		if err != nil {
+			return sessionPolicies{}, fmt.Errorf("%s: %w", env, err)
+		}
+		policies.roles[role] = policy
+	}
+	return policies, nil
+}
+
+func parseSessionPolicy(base sessionPolicy, spec string) (sessionPolicy, error) {
+	policy := base
+	for _, setting := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(setting), "=")
+		if !ok {
+			return sessionPolicy{}, fmt.Errorf("want key=duration, got %q", setting)
+		}
+		d, err := parseDuration(value)
+		if err != nil {
+			return sessionPolicy{}, fmt.Errorf("%s: %w", key, err)
+		}
+		switch key {
+		case "access_token_ttl":
+			policy.AccessTokenTTL = d
+		case "idle_timeout":
+			policy.IdleTimeout = d
+		case "max_age":
+			policy.MaxAge = d
+		default:
+			return sessionPolicy{}, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return policy, policy.validate()
+}
+
+func (p sessionPolicy) validate() error {
+	if p.AccessTokenTTL <= 0 || p.MaxAge <= 0 || p.IdleTimeout < 0 {
+		return fmt.Errorf("access token TTL and max age must be positive, idle timeout can't be negative")
+	}
+	return nil
+}
+
+// parseDuration is time.ParseDuration plus whole days, like "30d".
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/Ayannamdeo/chirpy/internal/middleware"
+	"github.com/google/uuid"
+)
+
+// Session is a refresh token family as the user sees it. The ID is the
+// family ID, which access tokens carry as their sid claim. ClientID is set
+// for sessions the user granted to an OAuth client.
+type Session struct {
+	ID           uuid.UUID `json:"id"`
+	ClientID     string    `json:"client_id,omitempty"`
+	DeviceLabel  string    `json:"device_label"`
+	UserAgent    string    `json:"user_agent"`
+	IPAddress    string    `json:"ip_address"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Current      bool      `json:"current"`
+}
+
+// endConsentSession revokes the caller's session once it has answered a
+// consent or device page. Those pages log in just to ask, so the session
+// has no use after that. The answer is already saved by then, so errors
+// are only logged.
+func (cfg *apiConfig) endConsentSession(r *http.Request) {
+	principal := middleware.FromContext(r.Context())
+	sessionID, err := uuid.Parse(principal.SessionID)
+	if err != nil {
+		return
+	}
+	revoked, err := cfg.db.RevokeSession(r.Context(), database.RevokeSessionParams{
+		FamilyID: sessionID,
+		UserID:   principal.UserID,
+	})
+	if err != nil {
+		log.Printf("Error revoking consent session %s: %v", sessionID, err)
+		return
+	}
+	if revoked == 0 {
+		return
+	}
+	if err := cfg.denylist.DenySession(r.Context(), sessionID); err != nil {
+		log.Printf("Error denying consent session %s: %v", sessionID, err)
+	}
+	cfg.audit(r, auditSessionRevoked, principal.UserID, principal.UserID, auditMeta{"session_id": sessionID, "reason": "consent"})
+}
+
+// describeUserAgent makes up a device label like "Firefox on Linux" for
+// clients that didn't name themselves.
+func describeUserAgent(ua string) string {
+	browser := ""
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	system := ""
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			system = o.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	default:
+		return "Unknown device"
+	}
+}
+
+func (cfg *apiConfig) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.FromContext(r.Context())
+	tokens, err := cfg.db.ListSessionsForUser(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list sessions", err)
+		return
+	}
+	sessions := []Session{}
+	for _, token := range tokens {
+		sessions = append(sessions, sessionFromRefreshToken(token, principal.SessionID))
+	}
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+func sessionFromRefreshToken(token database.RefreshToken, currentSessionID string) Session {
+	return Session{
+		ID:           token.FamilyID,
+		ClientID:     token.ClientID.String,
+		DeviceLabel:  token.DeviceLabel,
+		UserAgent:    token.UserAgent,
+		IPAddress:    token.IpAddress,
+		CreatedAt:    token.SessionStartedAt,
+		LastActiveAt: token.CreatedAt,
+		ExpiresAt:    token.ExpiresAt,
+		Current:      token.FamilyID.String() == currentSessionID,
+	}
+}
+
+func (cfg *apiConfig) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID format", err)
+		return
+	}
+	revoked, err := cfg.db.RevokeSession(r.Context(), database.RevokeSessionParams{
+		FamilyID: sessionID,
+		UserID:   userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "session not found", nil)
+		return
+	}
+	if err := cfg.denylist.DenySession(r.Context(), sessionID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access tokens", err)
+		return
+	}
+	cfg.audit(r, auditSessionRevoked, userID, userID, auditMeta{"session_id": sessionID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeOtherSessionsHandler is "log out everywhere else": every session
+// but the one the access token belongs to is revoked.
+func (cfg *apiConfig) revokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.FromContext(r.Context())
+	// tokens minted before sessions existed have no sid, which leaves
+	// uuid.Nil and so revokes every session
+	currentSessionID, _ := uuid.Parse(principal.SessionID)
+	families, err := cfg.db.RevokeOtherSessions(r.Context(), database.RevokeOtherSessionsParams{
+		UserID:   principal.UserID,
+		FamilyID: currentSessionID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	if err := cfg.denySessions(r.Context(), families); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access tokens", err)
+		return
+	}
+	cfg.audit(r, auditSessionRevoked, principal.UserID, principal.UserID, auditMeta{"all_but": principal.SessionID})
+	w.WriteHeader(http.StatusNoContent)
+}
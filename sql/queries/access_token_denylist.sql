@@ -0,0 +1,20 @@
+-- name: DenyAccessTokens :exec
+INSERT INTO access_token_denylist (kind, id, revoked_at, expires_at)
+VALUES (
+    $1,
+    $2,
+    NOW(),
+    $3
+)
+ON CONFLICT (kind, id) DO UPDATE
+SET revoked_at = NOW(), expires_at = GREATEST(access_token_denylist.expires_at, EXCLUDED.expires_at);
+
+-- name: ListAccessTokenDenylistSince :many
+SELECT * FROM access_token_denylist
+WHERE revoked_at > $1
+AND expires_at > NOW()
+ORDER BY revoked_at asc;
+
+-- name: DeleteExpiredAccessTokenDenylist :exec
+DELETE FROM access_token_denylist
+WHERE expires_at <= NOW();
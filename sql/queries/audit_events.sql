@@ -0,0 +1,22 @@
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (id, created_at, event_type, actor_id, user_id, ip_address, user_agent, metadata)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+);
+
+-- name: ListAuditEvents :many
+SELECT * FROM audit_events
+WHERE (sqlc.narg('event_type')::text IS NULL OR event_type = sqlc.narg('event_type'))
+AND (sqlc.narg('user_id')::uuid IS NULL OR actor_id = sqlc.narg('user_id') OR user_id = sqlc.narg('user_id'))
+AND (sqlc.narg('since')::timestamp IS NULL OR created_at >= sqlc.narg('since'))
+AND (sqlc.narg('until')::timestamp IS NULL OR created_at < sqlc.narg('until'))
+AND (sqlc.narg('before_created_at')::timestamp IS NULL OR (created_at, id) < (sqlc.narg('before_created_at'), sqlc.narg('before_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
@@ -0,0 +1,32 @@
+-- name: GetFailedAttempts :one
+SELECT * FROM failed_attempts WHERE key = $1;
+
+-- name: RecordFailedAttempt :one
+INSERT INTO failed_attempts (key, failures, last_failure_at)
+VALUES (
+    sqlc.arg(key),
+    1,
+    NOW()
+)
+ON CONFLICT (key) DO UPDATE
+SET failures = CASE
+        WHEN failed_attempts.last_failure_at < sqlc.arg(window_start) THEN 1
+        ELSE failed_attempts.failures + 1
+    END,
+    last_failure_at = NOW()
+RETURNING *;
+
+-- name: LockFailedAttempts :exec
+UPDATE failed_attempts
+SET locked_until = $1
+WHERE key = $2;
+
+-- name: ClearFailedAttempts :exec
+DELETE FROM failed_attempts
+WHERE key = $1;
+
+-- name: DeleteStaleFailedAttempts :exec
+DELETE FROM failed_attempts
+WHERE key LIKE sqlc.arg(key_pattern)
+AND last_failure_at < sqlc.arg(window_start)
+AND (locked_until IS NULL OR locked_until < NOW());
@@ -0,0 +1,12 @@
+-- name: UseMagicLink :execrows
+INSERT INTO magic_link_uses (jti, used_at, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2
+)
+ON CONFLICT (jti) DO NOTHING;
+
+-- name: DeleteExpiredMagicLinkUses :exec
+DELETE FROM magic_link_uses
+WHERE expires_at <= NOW();
@@ -0,0 +1,91 @@
+-- name: CreateOauthClient :one
+INSERT INTO oauth_clients (id, user_id, name, secret_hash, redirect_uris, scopes, created_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    NOW()
+)
+RETURNING *;
+
+-- name: GetOauthClient :one
+SELECT * FROM oauth_clients WHERE id = $1;
+
+-- name: ListOauthClientsForUser :many
+SELECT * FROM oauth_clients
+WHERE user_id = $1
+ORDER BY created_at desc;
+
+-- name: DeleteOauthClient :execrows
+DELETE FROM oauth_clients
+WHERE id = $1
+AND user_id = $2;
+
+-- name: CreateOauthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, created_at, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    NOW(),
+    $7
+);
+
+-- name: UseOauthAuthorizationCode :one
+UPDATE oauth_authorization_codes
+SET used_at = NOW()
+WHERE code_hash = $1
+AND used_at IS NULL
+AND expires_at > NOW()
+RETURNING *;
+
+-- name: CreateOauthDeviceCode :exec
+INSERT INTO oauth_device_codes (device_code_hash, user_code, client_id, scopes, created_at, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    NOW(),
+    $5
+);
+
+-- name: GetOauthDeviceCode :one
+SELECT * FROM oauth_device_codes WHERE device_code_hash = $1;
+
+-- name: GetPendingOauthDeviceCode :one
+SELECT * FROM oauth_device_codes
+WHERE user_code = $1
+AND status = 'pending'
+AND expires_at > NOW();
+
+-- name: DecideOauthDeviceCode :execrows
+UPDATE oauth_device_codes
+SET status = $2, user_id = $3
+WHERE user_code = $1
+AND status = 'pending'
+AND expires_at > NOW();
+
+-- name: TouchOauthDeviceCode :exec
+UPDATE oauth_device_codes
+SET last_polled_at = NOW()
+WHERE device_code_hash = $1;
+
+-- name: UseOauthDeviceCode :one
+UPDATE oauth_device_codes
+SET used_at = NOW()
+WHERE device_code_hash = $1
+AND status = 'approved'
+AND used_at IS NULL
+AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredOauthDeviceCodes :exec
+DELETE FROM oauth_device_codes
+WHERE expires_at <= NOW();
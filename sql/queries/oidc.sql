@@ -0,0 +1,34 @@
+-- name: CreateOidcLoginState :exec
+INSERT INTO oidc_login_states (state, nonce, code_verifier, device_label, created_at, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    NOW(),
+    $5
+);
+
+-- name: UseOidcLoginState :one
+DELETE FROM oidc_login_states
+WHERE state = $1
+AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredOidcLoginStates :exec
+DELETE FROM oidc_login_states
+WHERE expires_at <= NOW();
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities
+WHERE issuer = $1
+AND subject = $2;
+
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (issuer, subject, user_id, created_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW()
+);
@@ -1,23 +1,104 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at)
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id, expires_at, family_id, parent_token_hash, session_started_at, user_agent, ip_address, device_label, client_id, scopes, hashed)
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
-    $3
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    $10,
+    $11,
+    true
 )
 RETURNING *;
 
+-- name: HashLegacyRefreshToken :execrows
+-- Replaces a token stored before hashing with its hash, along with the
+-- parent pointers of the tokens it was rotated into.
+WITH children AS (
+    UPDATE refresh_tokens
+    SET parent_token_hash = sqlc.arg(token_hash)
+    WHERE parent_token_hash = sqlc.arg(token)
+)
+UPDATE refresh_tokens
+SET token_hash = sqlc.arg(token_hash), hashed = true
+WHERE token_hash = sqlc.arg(token)
+AND NOT hashed;
+
+-- name: ListLegacyRefreshTokens :many
+SELECT token_hash FROM refresh_tokens
+WHERE NOT hashed
+LIMIT $1;
+
+-- name: GetRefreshToken :one
+SELECT * FROM refresh_tokens WHERE token_hash = $1;
+
+-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens
+SET rotated_at = NOW(), updated_at = NOW()
+WHERE token_hash = $1
+AND rotated_at IS NULL
+AND revoked_at IS NULL;
+
 -- name: RevokeRefreshToken :one
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
-WHERE token = $1
+WHERE token_hash = $1
 RETURNING *;
 
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1
+AND revoked_at IS NULL;
+
 -- name: GetUserFromRefreshToken :one
 SELECT users.* FROM users
 JOIN refresh_tokens ON users.id = refresh_tokens.user_id
-WHERE refresh_tokens.token = $1
+WHERE refresh_tokens.token_hash = $1
 AND revoked_at IS NULL
+AND rotated_at IS NULL
 AND expires_at > NOW();
+
+-- name: RevokeAllRefreshTokensForUser :many
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+AND revoked_at IS NULL
+RETURNING family_id;
+
+-- name: ListSessionsForUser :many
+SELECT * FROM refresh_tokens
+WHERE user_id = $1
+AND revoked_at IS NULL
+AND rotated_at IS NULL
+AND expires_at > NOW()
+ORDER BY created_at desc;
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1
+AND user_id = $2
+AND revoked_at IS NULL;
+
+-- name: RevokeOtherSessions :many
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+AND family_id <> $2
+AND revoked_at IS NULL
+RETURNING family_id;
+
+-- name: RevokeRefreshTokensForClient :many
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE client_id = $1
+AND revoked_at IS NULL
+RETURNING family_id;
@@ -0,0 +1,49 @@
+-- name: UpsertTotpSecret :one
+INSERT INTO totp_secrets (user_id, secret, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+WHERE totp_secrets.confirmed_at IS NULL
+RETURNING *;
+
+-- name: GetTotpSecret :one
+SELECT * FROM totp_secrets WHERE user_id = $1;
+
+-- name: ConfirmTotpSecret :execrows
+UPDATE totp_secrets
+SET confirmed_at = NOW(), last_used_step = $2
+WHERE user_id = $1
+AND confirmed_at IS NULL;
+
+-- name: UseTotpStep :execrows
+UPDATE totp_secrets
+SET last_used_step = $2
+WHERE user_id = $1
+AND last_used_step < $2;
+
+-- name: DeleteTotpSecret :exec
+DELETE FROM totp_secrets
+WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (code_hash, user_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+);
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = NOW()
+WHERE code_hash = $1
+AND user_id = $2
+AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1;
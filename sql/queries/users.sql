@@ -12,11 +12,20 @@ RETURNING *;
 -- name: GetUserByEmail :one
 SELECT * FROM users WHERE email = $1;
 
+-- name: GetUserById :one
+SELECT * FROM users WHERE id = $1;
+
 -- name: UpdateUserById :one
 UPDATE users
-SET email = $1, hashed_password = $2, updated_at = NOW()
+SET email = $1,
+    hashed_password = $2,
+    email_verified_at = CASE
+        WHEN $1 <> email THEN NULL
+        ELSE email_verified_at
+    END,
+    updated_at = NOW()
 WHERE id = $3
-RETURNING id, created_at, updated_at, email, is_chirpy_red;
+RETURNING *;
 
 -- name: UpgradeToChirpyRed :one
 UPDATE users
@@ -26,3 +35,62 @@ RETURNING *;
 
 -- name: DeleteAllUsers :exec
 DELETE FROM users;
+
+-- name: RehashUserPassword :execrows
+UPDATE users
+SET hashed_password = sqlc.arg(new_hash)
+WHERE id = sqlc.arg(id)
+AND hashed_password = sqlc.arg(old_hash);
+
+-- name: VerifyUserEmail :execrows
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1
+AND email = $2
+AND email_verified_at IS NULL;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $1, updated_at = NOW()
+WHERE id = $2;
+
+-- name: SetUserRole :one
+UPDATE users
+SET role = $1, updated_at = NOW()
+WHERE id = $2
+RETURNING *;
+
+-- name: SetUserRoleByEmail :one
+UPDATE users
+SET role = $1, updated_at = NOW()
+WHERE email = $2
+AND email_verified_at IS NOT NULL
+RETURNING *;
+
+-- name: ScheduleUserDeletion :exec
+UPDATE users
+SET delete_after = $1, updated_at = NOW()
+WHERE id = $2;
+
+-- name: CancelUserDeletion :execrows
+UPDATE users
+SET delete_after = NULL, updated_at = NOW()
+WHERE id = $1
+AND delete_after IS NOT NULL;
+
+-- name: PurgeDeletedUsers :many
+DELETE FROM users
+WHERE delete_after <= NOW()
+RETURNING id;
+
+-- name: PatchUser :one
+UPDATE users
+SET email = coalesce(sqlc.narg('email'), email),
+    hashed_password = coalesce(sqlc.narg('hashed_password'), hashed_password),
+    email_verified_at = CASE
+        WHEN sqlc.narg('email') IS NOT NULL AND sqlc.narg('email') <> email THEN NULL
+        ELSE email_verified_at
+    END,
+    updated_at = NOW()
+WHERE id = sqlc.arg('id')
+RETURNING *;
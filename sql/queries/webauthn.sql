@@ -0,0 +1,57 @@
+-- name: CreateWebauthnCredential :one
+INSERT INTO webauthn_credentials (id, user_id, credential_id, public_key, sign_count, transports, name, created_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    NOW()
+)
+RETURNING *;
+
+-- name: GetWebauthnCredentialByCredentialID :one
+SELECT * FROM webauthn_credentials
+WHERE credential_id = $1;
+
+-- name: ListWebauthnCredentialsForUser :many
+SELECT * FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at DESC;
+
+-- name: UpdateWebauthnCredentialSignCount :exec
+UPDATE webauthn_credentials
+SET sign_count = $2, last_used_at = NOW()
+WHERE id = $1;
+
+-- name: DeleteWebauthnCredential :execrows
+DELETE FROM webauthn_credentials
+WHERE id = $1
+AND user_id = $2;
+
+-- name: DeleteWebauthnCredentialsForUser :exec
+DELETE FROM webauthn_credentials
+WHERE user_id = $1;
+
+-- name: CreateWebauthnChallenge :exec
+INSERT INTO webauthn_challenges (challenge, purpose, user_id, created_at, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW(),
+    $4
+);
+
+-- name: UseWebauthnChallenge :one
+DELETE FROM webauthn_challenges
+WHERE challenge = $1
+AND purpose = $2
+AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredWebauthnChallenges :exec
+DELETE FROM webauthn_challenges
+WHERE expires_at <= NOW();
@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+add column family_id uuid not null default gen_random_uuid(),
+add column parent_token text,
+add column rotated_at timestamp;
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_family_id_idx;
+
+ALTER TABLE refresh_tokens
+drop column rotated_at,
+drop column parent_token,
+drop column family_id;
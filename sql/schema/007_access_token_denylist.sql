@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE access_token_denylist (
+kind text not null check (kind in ('jti', 'sid')),
+id uuid not null,
+revoked_at timestamp not null default now(),
+expires_at timestamp not null,
+primary key (kind, id)
+);
+
+CREATE INDEX access_token_denylist_revoked_at_idx ON access_token_denylist (revoked_at);
+
+-- +goose Down
+DROP TABLE access_token_denylist;
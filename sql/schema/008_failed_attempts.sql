@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE failed_attempts (
+key text primary key,
+failures integer not null,
+last_failure_at timestamp not null,
+locked_until timestamp
+);
+
+-- +goose Down
+DROP TABLE failed_attempts;
@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE totp_secrets (
+user_id uuid primary key,
+FOREIGN KEY(user_id) REFERENCES users(id) on delete cascade,
+secret text not null,
+confirmed_at timestamp,
+last_used_step bigint not null default 0,
+created_at timestamp not null default now()
+);
+
+CREATE TABLE recovery_codes (
+code_hash text primary key,
+user_id uuid not null,
+FOREIGN KEY(user_id) REFERENCES users(id) on delete cascade,
+created_at timestamp not null default now(),
+used_at timestamp
+);
+
+CREATE INDEX recovery_codes_user_id_idx ON recovery_codes (user_id);
+
+-- +goose Down
+DROP TABLE recovery_codes;
+DROP TABLE totp_secrets;
@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE users
+add column email_verified_at timestamp;
+
+-- accounts from before verification existed can't be asked to verify now
+UPDATE users SET email_verified_at = created_at;
+
+-- +goose Down
+ALTER TABLE users
+drop column email_verified_at;
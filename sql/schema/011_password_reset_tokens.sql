@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE password_reset_tokens (
+token_hash text primary key,
+user_id uuid not null,
+FOREIGN KEY(user_id) REFERENCES users(id) on delete cascade,
+created_at timestamp not null,
+expires_at timestamp not null,
+used_at timestamp
+);
+
+CREATE INDEX password_reset_tokens_user_id_idx ON password_reset_tokens (user_id);
+
+-- +goose Down
+DROP TABLE password_reset_tokens;
@@ -0,0 +1,26 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+add column session_started_at timestamp,
+add column user_agent text not null default '',
+add column ip_address text not null default '',
+add column device_label text not null default '';
+
+-- a family's first token was minted when its session started
+UPDATE refresh_tokens
+SET session_started_at = first.started_at
+FROM (
+  SELECT family_id, MIN(created_at) AS started_at
+  FROM refresh_tokens
+  GROUP BY family_id
+) AS first
+WHERE refresh_tokens.family_id = first.family_id;
+
+ALTER TABLE refresh_tokens
+alter column session_started_at set not null;
+
+-- +goose Down
+ALTER TABLE refresh_tokens
+drop column device_label,
+drop column ip_address,
+drop column user_agent,
+drop column session_started_at;
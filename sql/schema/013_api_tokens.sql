@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE api_tokens (
+id uuid primary key default gen_random_uuid(),
+user_id uuid not null,
+FOREIGN KEY(user_id) REFERENCES users(id) on delete cascade,
+name text not null,
+token_hash text not null unique,
+scopes text[] not null,
+created_at timestamp not null,
+expires_at timestamp,
+last_used_at timestamp,
+revoked_at timestamp
+);
+
+CREATE INDEX api_tokens_user_id_idx ON api_tokens (user_id);
+
+-- +goose Down
+DROP TABLE api_tokens;
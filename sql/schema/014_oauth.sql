@@ -0,0 +1,41 @@
+-- +goose Up
+CREATE TABLE oauth_clients (
+id text primary key,
+user_id uuid not null,
+FOREIGN KEY(user_id) REFERENCES users(id) on delete cascade,
+name text not null,
+secret_hash text,
+redirect_uris text[] not null,
+scopes text[] not null,
+created_at timestamp not null
+);
+
+CREATE INDEX oauth_clients_user_id_idx ON oauth_clients (user_id);
+
+CREATE TABLE oauth_authorization_codes (
+code_hash text primary key,
+client_id text not null,
+FOREIGN KEY(client_id) REFERENCES oauth_clients(id) on delete cascade,
+user_id uuid not null,
+FOREIGN KEY(user_id) REFERENCES users(id) on delete cascade,
+redirect_uri text not null,
+scopes text[] not null,
+code_challenge text not null,
+created_at timestamp not null,
+expires_at timestamp not null,
+used_at timestamp
+);
+
+-- sessions of OAuth clients are limited to the scopes the user granted;
+-- first-party sessions have neither column set
+ALTER TABLE refresh_tokens
+add column client_id text REFERENCES oauth_clients(id) on delete cascade,
+add column scopes text[];
+
+-- +goose Down
+ALTER TABLE refresh_tokens
+drop column scopes,
+drop column client_id;
+
+DROP TABLE oauth_authorization_codes;
+DROP TABLE oauth_clients;
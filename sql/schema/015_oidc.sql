@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE user_identities (
+issuer text not null,
+subject text not null,
+user_id uuid not null,
+FOREIGN KEY(user_id) REFERENCES users(id) on delete cascade,
+created_at timestamp not null,
+PRIMARY KEY(issuer, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
+
+CREATE TABLE oidc_login_states (
+state text primary key,
+nonce text not null,
+code_verifier text not null,
+device_label text not null,
+created_at timestamp not null,
+expires_at timestamp not null
+);
+
+-- +goose Down
+DROP TABLE oidc_login_states;
+DROP TABLE user_identities;
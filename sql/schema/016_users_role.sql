@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE users
+add column role text not null default 'user'
+CHECK (role IN ('user', 'moderator', 'admin'));
+
+-- +goose Down
+ALTER TABLE users
+drop column role;
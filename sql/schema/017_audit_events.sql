@@ -0,0 +1,33 @@
+-- +goose Up
+CREATE TABLE audit_events (
+id uuid primary key default gen_random_uuid(),
+created_at timestamp not null,
+event_type text not null,
+actor_id uuid,
+user_id uuid,
+ip_address text not null,
+user_agent text not null,
+metadata jsonb not null default '{}'
+);
+
+CREATE INDEX audit_events_created_at_idx ON audit_events (created_at, id);
+CREATE INDEX audit_events_actor_id_idx ON audit_events (actor_id);
+CREATE INDEX audit_events_user_id_idx ON audit_events (user_id);
+
+-- The log has to outlive the accounts it is about, so there are no foreign
+-- keys, and nothing may rewrite it.
+-- +goose StatementBegin
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER audit_events_append_only
+BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_events
+FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+-- +goose Down
+DROP TABLE audit_events;
+DROP FUNCTION audit_events_append_only();
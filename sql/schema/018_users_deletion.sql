@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE users
+add column delete_after timestamp;
+
+CREATE INDEX users_delete_after_idx ON users (delete_after) WHERE delete_after IS NOT NULL;
+
+-- +goose Down
+ALTER TABLE users
+drop column delete_after;
@@ -0,0 +1,31 @@
+-- +goose Up
+-- Refresh tokens are stored as a keyed hash from now on. The key isn't in
+-- the database, so existing tokens can't be hashed here: they keep their
+-- raw value with hashed = false, and the server hashes them on startup or
+-- when they are next used. Nobody gets logged out.
+ALTER TABLE refresh_tokens
+rename column token to token_hash;
+
+ALTER TABLE refresh_tokens
+rename column parent_token to parent_token_hash;
+
+ALTER TABLE refresh_tokens
+add column hashed boolean not null default false;
+
+CREATE INDEX refresh_tokens_unhashed_idx ON refresh_tokens (token_hash) WHERE NOT hashed;
+
+-- +goose Down
+-- Tokens that were hashed can't be turned back into raw values, so their
+-- sessions end.
+DROP INDEX refresh_tokens_unhashed_idx;
+
+DELETE FROM refresh_tokens WHERE hashed;
+
+ALTER TABLE refresh_tokens
+drop column hashed;
+
+ALTER TABLE refresh_tokens
+rename column parent_token_hash to parent_token;
+
+ALTER TABLE refresh_tokens
+rename column token_hash to token;
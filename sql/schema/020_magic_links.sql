@@ -0,0 +1,11 @@
+-- +goose Up
+-- Magic links are signed tokens, so only the ones already used need
+-- remembering, and only until they would have expired anyway.
+CREATE TABLE magic_link_uses (
+jti uuid primary key,
+used_at timestamp not null,
+expires_at timestamp not null
+);
+
+-- +goose Down
+DROP TABLE magic_link_uses;
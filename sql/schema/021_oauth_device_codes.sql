@@ -0,0 +1,21 @@
+-- +goose Up
+-- Device authorization grants (RFC 8628). The device code is the client's
+-- secret and stored hashed; the user code is short-lived and only good
+-- for approving, so it is kept as typed.
+CREATE TABLE oauth_device_codes (
+device_code_hash text primary key,
+user_code text unique not null,
+client_id text not null,
+FOREIGN KEY(client_id) REFERENCES oauth_clients(id) on delete cascade,
+scopes text[] not null,
+status text not null default 'pending',
+user_id uuid,
+FOREIGN KEY(user_id) REFERENCES users(id) on delete cascade,
+created_at timestamp not null,
+expires_at timestamp not null,
+last_polled_at timestamp,
+used_at timestamp
+);
+
+-- +goose Down
+DROP TABLE oauth_device_codes;
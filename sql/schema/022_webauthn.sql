@@ -0,0 +1,32 @@
+-- +goose Up
+-- Passkeys. credential_id is chosen by the authenticator and is how
+-- logins name the credential; public_key is COSE encoded.
+CREATE TABLE webauthn_credentials (
+id uuid primary key,
+user_id uuid not null,
+FOREIGN KEY(user_id) REFERENCES users(id) on delete cascade,
+credential_id bytea unique not null,
+public_key bytea not null,
+sign_count bigint not null,
+transports text[] not null,
+name text not null,
+created_at timestamp not null,
+last_used_at timestamp
+);
+
+CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
+
+-- Challenges of ceremonies in progress. Registrations are for the
+-- logged-in user, logins don't know the user until the answer comes back.
+CREATE TABLE webauthn_challenges (
+challenge text primary key,
+purpose text not null,
+user_id uuid,
+FOREIGN KEY(user_id) REFERENCES users(id) on delete cascade,
+created_at timestamp not null,
+expires_at timestamp not null
+);
+
+-- +goose Down
+DROP TABLE webauthn_challenges;
+DROP TABLE webauthn_credentials;
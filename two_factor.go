@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Ayannamdeo/chirpy/internal/auth"
+	"github.com/Ayannamdeo/chirpy/internal/database"
+	"github.com/Ayannamdeo/chirpy/internal/middleware"
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer         = "Chirpy"
+	recoveryCodeCount  = 10
+	twoFactorChallenge = 5 * time.Minute
+)
+
+func (cfg *apiConfig) twoFactorEnabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	secret, err := cfg.db.GetTotpSecret(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return secret.ConfirmedAt.Valid, nil
+}
+
+// respondWithTwoFactorChallenge answers a correct password for an account
+// with 2FA on. The challenge token only works on /api/login/2fa.
+func (cfg *apiConfig) respondWithTwoFactorChallenge(w http.ResponseWriter, user database.User) {
+	challenge, err := auth.MakePurposeToken(user.ID, "", auth.PurposeTwoFactor, cfg.jwtKeys, twoFactorChallenge)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't make 2fa challenge", err)
+		return
+	}
+	type response struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		ChallengeToken    string `json:"challenge_token"`
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+	})
+}
+
+// checkSecondFactor accepts either a TOTP code or an unused recovery code
+// and burns it, so neither can be replayed.
+func (cfg *apiConfig) checkSecondFactor(ctx context.Context, secret database.TotpSecret, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := auth.ValidateTOTP(secret.Secret, code, time.Now(), secret.LastUsedStep)
+		if !ok {
+			return false, nil
+		}
+		used, err := cfg.db.UseTotpStep(ctx, database.UseTotpStepParams{
+			UserID:       secret.UserID,
+			LastUsedStep: step,
+		})
+		return used == 1, err
+	}
+	if recoveryCode != "" {
+		used, err := cfg.db.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode)),
+			UserID:   secret.UserID,
+		})
+		return used == 1, err
+	}
+	return false, nil
+}
+
+// verifySecondFactor runs checkSecondFactor behind the per-user 2fa
+// throttle and answers the request itself when the check doesn't pass.
+func (cfg *apiConfig) verifySecondFactor(w http.ResponseWriter, r *http.Request, secret database.TotpSecret, code, recoveryCode string) bool {
+	key := secret.UserID.String()
+	wait, err := cfg.twoFactorThrottle.Check(r.Context(), key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check failed 2fa attempts", err)
+		return false
+	}
+	if wait > 0 {
+		respondWithRetryAfter(w, wait, "too many failed 2fa attempts, try again later")
+		return false
+	}
+	ok, err := cfg.checkSecondFactor(r.Context(), secret, code, recoveryCode)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check 2fa code", err)
+		return false
+	}
+	if !ok {
+		cfg.audit(r, auditTwoFactorFailed, uuid.Nil, secret.UserID, nil)
+		if _, err := cfg.twoFactorThrottle.Fail(r.Context(), key); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record failed 2fa attempt", err)
+			return false
+		}
+		respondWithError(w, http.StatusUnauthorized, "invalid 2fa code", nil)
+		return false
+	}
+	if err := cfg.twoFactorThrottle.Reset(r.Context(), key); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset failed 2fa attempts", err)
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) replaceRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.db.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		err := cfg.db.CreateRecoveryCode(ctx, database.CreateRecoveryCodeParams{
+			CodeHash: auth.HashToken(code),
+			UserID:   userID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func (cfg *apiConfig) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	user, err := cfg.db.GetUserById(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate 2fa secret", err)
+		return
+	}
+	_, err = cfg.db.UpsertTotpSecret(r.Context(), database.UpsertTotpSecretParams{
+		UserID: userID,
+		Secret: secret,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, "2fa is already enabled", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save 2fa secret", err)
+		return
+	}
+	type response struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauth_uri"`
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Secret:     secret,
+		OtpauthURI: auth.TOTPURI(totpIssuer, user.Email, secret),
+	})
+}
+
+func (cfg *apiConfig) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	reqBody := struct {
+		Code string `json:"code"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	secret, err := cfg.db.GetTotpSecret(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "2fa enrolment not started", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get 2fa secret", err)
+		return
+	}
+	if secret.ConfirmedAt.Valid {
+		respondWithError(w, http.StatusConflict, "2fa is already enabled", nil)
+		return
+	}
+	step, ok := auth.ValidateTOTP(secret.Secret, reqBody.Code, time.Now(), secret.LastUsedStep)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "invalid 2fa code", nil)
+		return
+	}
+	confirmed, err := cfg.db.ConfirmTotpSecret(r.Context(), database.ConfirmTotpSecretParams{
+		UserID:       userID,
+		LastUsedStep: step,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable 2fa", err)
+		return
+	}
+	if confirmed == 0 {
+		respondWithError(w, http.StatusConflict, "2fa is already enabled", nil)
+		return
+	}
+	codes, err := cfg.replaceRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes", err)
+		return
+	}
+	cfg.audit(r, auditTwoFactorEnabled, userID, userID, nil)
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes: codes,
+	})
+}
+
+func (cfg *apiConfig) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.FromContext(r.Context()).UserID
+	reqBody := struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	secret, err := cfg.db.GetTotpSecret(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !secret.ConfirmedAt.Valid) {
+		respondWithError(w, http.StatusNotFound, "2fa is not enabled", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get 2fa secret", err)
+		return
+	}
+	if !cfg.verifySecondFactor(w, r, secret, reqBody.Code, reqBody.RecoveryCode) {
+		return
+	}
+	if err := cfg.db.DeleteTotpSecret(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable 2fa", err)
+		return
+	}
+	if err := cfg.db.DeleteRecoveryCodes(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete recovery codes", err)
+		return
+	}
+	cfg.audit(r, auditTwoFactorDisabled, userID, userID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) loginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody := struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+		DeviceLabel    string `json:"device_label"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	claims, err := auth.ParsePurposeToken(reqBody.ChallengeToken, auth.PurposeTwoFactor, cfg.jwtKeys, cfg.denylist)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid or expired challenge_token", err)
+		return
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid challenge_token", err)
+		return
+	}
+	secret, err := cfg.db.GetTotpSecret(r.Context(), userID)
+	if err != nil || !secret.ConfirmedAt.Valid {
+		respondWithError(w, http.StatusUnauthorized, "2fa is not enabled", err)
+		return
+	}
+	if !cfg.verifySecondFactor(w, r, secret, reqBody.Code, reqBody.RecoveryCode) {
+		return
+	}
+	jti, err := uuid.Parse(claims.ID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid challenge_token", err)
+		return
+	}
+	if err := cfg.denylist.DenyToken(r.Context(), jti, claims.ExpiresAt.Time); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't use up challenge_token", err)
+		return
+	}
+	user, err := cfg.db.GetUserById(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find user", err)
+		return
+	}
+	cfg.respondWithSession(w, r, user, reqBody.DeviceLabel, "2fa")
+}